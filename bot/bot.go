@@ -1,13 +1,15 @@
 package bot
 
 import (
+	"bytes"
 	"context"
 	"errors"
-	"fmt"
+	"html/template"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/viper"
@@ -16,13 +18,25 @@ import (
 	"github.com/ItalyPaleAle/rss-bot/feeds"
 )
 
+// Interval for checking for feeds that are due for an update
+const feedSchedulerInterval = 30 * time.Second
+
+// Maximum length of the caption of a photo, as allowed by Telegram
+const maxCaptionLength = 1024
+
 // RSSBot is the class that manages the RSS bot
 type RSSBot struct {
-	log    *log.Logger
-	bot    *tb.Bot
-	feeds  *feeds.Feeds
-	ctx    context.Context
-	cancel context.CancelFunc
+	log     *log.Logger
+	bot     *tb.Bot
+	feeds   *feeds.Feeds
+	limiter *rateLimiter
+	webhook *webhookPoller
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	// Feeds found on web pages, waiting for users to choose one
+	choices     map[string]*feedChoice
+	choicesLock sync.Mutex
 }
 
 // Init the object
@@ -30,6 +44,15 @@ func (b *RSSBot) Init() (err error) {
 	// Init the logger
 	b.log = log.New(os.Stdout, "rss-bot: ", log.Ldate|log.Ltime|log.LUTC)
 
+	// Context, that can be used to stop the bot
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+
+	// Init the rate limiter for sending messages
+	b.limiter = newRateLimiter()
+
+	// Init the list of feeds waiting for users to choose one
+	b.choices = make(map[string]*feedChoice)
+
 	// Get the auth key
 	// "token" is the default value in the config file
 	authKey := viper.GetString("TelegramAuthToken")
@@ -38,7 +61,11 @@ func (b *RSSBot) Init() (err error) {
 	}
 
 	// Poller
-	var poller tb.Poller = &tb.LongPoller{Timeout: 10 * time.Second}
+	// If we have a public URL for the webhook, use that; otherwise, fall back to long polling
+	poller, err := b.getPoller()
+	if err != nil {
+		return err
+	}
 
 	// Check if we're restricting the bot to certain users only
 	allowedUsers := b.getAllowedUsers()
@@ -64,7 +91,6 @@ func (b *RSSBot) Init() (err error) {
 	}
 
 	// Create the bot object
-	// TODO: Enable support for webhook: https://godoc.org/gopkg.in/tucnak/telebot.v2#Webhook
 	b.bot, err = tb.NewBot(tb.Settings{
 		Token:   authKey,
 		Poller:  poller,
@@ -79,9 +105,6 @@ func (b *RSSBot) Init() (err error) {
 
 // Start the background workers
 func (b *RSSBot) Start() error {
-	// Context, that can be used to stop the bot
-	b.ctx, b.cancel = context.WithCancel(context.Background())
-
 	// Init the feeds object
 	b.feeds = &feeds.Feeds{}
 	err := b.feeds.Init(b.ctx)
@@ -95,13 +118,32 @@ func (b *RSSBot) Start() error {
 		return err
 	}
 
+	// When using a webhook, register it; when using long polling, remove any webhook that might have been set before, or getUpdates would fail
+	if b.webhook != nil {
+		err = b.webhook.Register(b.bot)
+	} else {
+		err = b.bot.RemoveWebhook()
+	}
+	if err != nil {
+		return err
+	}
+
 	// Start the background worker
 	go b.backgroundWorker()
 
 	// Start the bot
+	// This is a blocking call that returns when the bot is stopped
 	log.Println("Bot starting")
 	b.bot.Start()
 
+	// Remove the webhook when we're stopping
+	if b.webhook != nil {
+		err = b.bot.RemoveWebhook()
+		if err != nil {
+			b.log.Println("Error while removing the webhook:", err)
+		}
+	}
+
 	return nil
 }
 
@@ -110,31 +152,32 @@ func (b *RSSBot) Stop() {
 	b.cancel()
 }
 
-// In background, start updating feeds periodically and send messages on new posts
+// In background, start updating feeds periodically and deliver messages on new posts
 // Also watch for the stop message
 func (b *RSSBot) backgroundWorker() {
 	// Sleep for 2 seconds
 	time.Sleep(2 * time.Second)
 
-	// Channel for receiving messages to send
-	msgCh := make(chan feeds.UpdateMessage)
-	b.feeds.SetUpdateChan(msgCh)
+	// Channel for receiving notifications of new messages in the outbox
+	// There's no need for more than one notification to be pending
+	outboxCh := make(chan struct{}, 1)
+	b.feeds.SetOutboxChan(outboxCh)
+
+	// Start delivering messages, including those left in the outbox from before the bot was (re-)started
+	go b.deliveryWorker(outboxCh)
 
 	// Queue an update right away
 	b.feeds.QueueUpdate()
 
-	// Ticker for updates
-	ticker := time.NewTicker(viper.GetDuration("FeedUpdateInterval") * time.Second)
+	// Ticker for checking for feeds that are due for an update, and digests that are due
+	ticker := time.NewTicker(feedSchedulerInterval)
 	for {
 		select {
-		// On the interval, queue an update
+		// On the interval, queue an update and send the digests
 		case <-ticker.C:
 			b.feeds.QueueUpdate()
-
-		// Send messages on new posts
-		case msg := <-msgCh:
-			// This method logs errors already
-			b.sendFeedUpdate(tb.ChatID(msg.ChatId), &msg)
+			// Error is already logged
+			_ = b.feeds.SendDueDigests()
 
 		// Context canceled
 		case <-b.ctx.Done():
@@ -154,52 +197,172 @@ func (b *RSSBot) escapeHTMLEntities(s string) string {
 }
 
 // Sends a message with a feed's post
-func (b *RSSBot) sendFeedUpdate(recipient tb.Recipient, msg *feeds.UpdateMessage) {
+// If the post has a photo, it's sent with the message as caption; if the message is too long for a caption, the photo is sent separately after it
+// The caller must have reserved a slot with the rate limiter for the first message
+// If the chat is over its budget for sending the separate photo, the photo is queued in the outbox as its own message; errors sending the photo are logged only, because the message itself was delivered
+// If silent is true, the message doesn't notify the chat, such as during its quiet hours
+func (b *RSSBot) sendFeedUpdate(recipient tb.Recipient, msg *feeds.UpdateMessage, silent bool) error {
+	// Messages with the photo of a post only
+	if msg.PhotoOnly {
+		// The photo might have been removed if the chat disabled images in the meanwhile
+		if msg.Post.Photo == "" {
+			return nil
+		}
+		err := b.sendPhoto(recipient, msg.ChatId, msg.Post.Photo, "", &tb.SendOptions{
+			DisableNotification: true,
+		})
+		if err != nil {
+			b.log.Printf("Error sending photo %s to chat %d: %s\n", msg.Post.Photo, msg.ChatId, err.Error())
+			return err
+		}
+		return nil
+	}
+
+	// Text messages are sent as-is
+	if msg.Text != "" {
+		_, err := b.bot.Send(recipient, msg.Text, &tb.SendOptions{
+			DisableWebPagePreview: true,
+			DisableNotification:   silent,
+		})
+		if err != nil {
+			b.log.Printf("Error sending message to chat %d: %s\n", msg.ChatId, err.Error())
+			return err
+		}
+		return nil
+	}
+
+	text := b.formatUpdateMessage(msg)
+
+	// If there's a photo and the text fits in a caption, send a single message with both
+	photo := msg.Post.Photo
+	if photo != "" && htmlTextLength(text) <= maxCaptionLength {
+		err := b.sendPhoto(recipient, msg.ChatId, photo, text, &tb.SendOptions{
+			ParseMode:           tb.ModeHTML,
+			DisableNotification: silent,
+		})
+		if err == nil {
+			return nil
+		}
+
+		// If we were rate-limited or the chat can't be reached, return the error so the caller can handle it
+		if isChatOrFloodError(err) {
+			b.log.Printf("Error sending message to chat %d: %s\n", msg.ChatId, err.Error())
+			return err
+		}
+
+		// Otherwise, the photo couldn't be sent: send the text only
+		b.log.Printf("Error sending photo %s to chat %d, sending the message without it: %s\n", photo, msg.ChatId, err.Error())
+		photo = ""
+		wait, err := b.limiter.Reserve(b.ctx, msg.ChatId)
+		if err != nil {
+			// Context was canceled
+			return err
+		}
+		if wait > 0 {
+			// If the chat is over its budget, remove the photo from the message so it's not tried again, and let the caller postpone it
+			err = b.feeds.RemovePhoto(msg)
+			if err != nil {
+				return err
+			}
+			return &rateLimitedError{Wait: wait}
+		}
+	}
+
 	// Send title
 	_, err := b.bot.Send(
 		recipient,
-		b.formatUpdateMessage(msg),
+		text,
 		&tb.SendOptions{
 			ParseMode:             tb.ModeHTML,
 			DisableWebPagePreview: true,
+			DisableNotification:   silent,
 		},
 	)
 	if err != nil {
 		b.log.Printf("Error sending message to chat %d: %s\n", msg.ChatId, err.Error())
-		return
+		return err
 	}
 
-	// Send photo, if any
-	// Note that this might fail, for example if the image is too big (>5MB)
-	if msg.Post.Photo != "" {
-		_, err = b.bot.Send(
-			recipient,
-			&tb.Photo{File: tb.FromURL(msg.Post.Photo)},
-			&tb.SendOptions{
-				// Do not send notifications for subsequent messages
-				DisableNotification: true,
-			},
-		)
+	// Send the photo separately, if any, when the text is too long for a caption
+	if photo != "" {
+		// If the chat is over its budget, queue the photo as its own message rather than blocking the delivery of other messages
+		// If we're stopping, the photo is queued too, so it's sent when the bot is started again; the message itself was delivered, so this isn't an error for the caller
+		wait, err := b.limiter.Reserve(b.ctx, msg.ChatId)
+		if err != nil || wait > 0 {
+			// Error is already logged
+			_ = b.feeds.QueuePhoto(msg, time.Now().Add(wait))
+			return nil
+		}
+		err = b.sendPhoto(recipient, msg.ChatId, photo, "", &tb.SendOptions{
+			// Do not send notifications for subsequent messages
+			DisableNotification: true,
+		})
 		if err != nil {
-			b.log.Printf("Error sending photo %s to chat %d: %s\n", msg.Post.Photo, msg.ChatId, err.Error())
+			b.log.Printf("Error sending photo %s to chat %d: %s\n", photo, msg.ChatId, err.Error())
+			// If we were rate-limited by Telegram, try again later
+			if wait, ok := floodWait(err); ok {
+				b.limiter.Block(msg.ChatId, wait)
+				// Error is already logged
+				_ = b.feeds.QueuePhoto(msg, time.Now().Add(wait))
+			}
 		}
 	}
+
+	return nil
+}
+
+// Sends a photo, with an optional caption
+// Telegram fetches the photo by URL; if that fails (for example, if the image is too big, behind hotlink protection, or in an unsupported format), the image is downloaded, converted, and uploaded instead
+func (b *RSSBot) sendPhoto(recipient tb.Recipient, chatId int64, url string, caption string, opts *tb.SendOptions) error {
+	_, err := b.bot.Send(recipient, &tb.Photo{File: tb.FromURL(url), Caption: caption}, opts)
+	if err == nil || isChatOrFloodError(err) {
+		return err
+	}
+
+	// Download the image and upload it
+	b.log.Printf("Telegram couldn't fetch photo %s for chat %d, uploading it instead: %s\n", url, chatId, err.Error())
+	data, dlErr := b.feeds.DownloadImage(url)
+	if dlErr != nil {
+		b.log.Printf("Error downloading photo %s: %s\n", url, dlErr.Error())
+		return err
+	}
+	_, err = b.bot.Send(recipient, &tb.Photo{File: tb.FromReader(bytes.NewReader(data)), Caption: caption}, opts)
+	return err
+}
+
+// Returns true if the error is because we were rate-limited or the chat can't be reached, rather than because of the message's content
+func isChatOrFloodError(err error) bool {
+	if _, ok := floodWait(err); ok {
+		return true
+	}
+	kind, _ := classifyChatError(err)
+	return kind != chatErrorNone
 }
 
-// Formats a message with an update
+// Formats a message with an update, using the template of the subscription or chat if any
+// If the custom template can't be rendered, this falls back to the default one
 func (b *RSSBot) formatUpdateMessage(msg *feeds.UpdateMessage) string {
-	// Note: the msg.Feed object might be nil when passed to this method
-	out := ""
-	if msg.Feed != nil {
-		out += fmt.Sprintf("🎙 %s:\n", b.escapeHTMLEntities(msg.Feed.Title))
+	data := newMessageTemplateData(msg)
+
+	if msg.Template != "" {
+		tpl, err := parseMessageTemplate(msg.Template)
+		if err == nil {
+			var out string
+			out, err = executeMessageTemplate(tpl, data)
+			if err == nil {
+				return out
+			}
+		}
+		b.log.Printf("Error rendering the template for chat %d, using the default one: %s\n", msg.ChatId, err.Error())
 	}
 
-	// Add the content
-	out += fmt.Sprintf("📬 <b>%s</b>\n🕓 %s\n🔗 %s\n",
-		b.escapeHTMLEntities(msg.Post.Title),
-		b.escapeHTMLEntities(msg.Post.Date.UTC().Format("Mon, 02 Jan 2006 15:04:05 MST")),
-		b.escapeHTMLEntities(msg.Post.Link),
-	)
+	// The default template is always valid
+	tpl := template.Must(parseMessageTemplate(templatePresets[defaultTemplatePreset]))
+	out, err := executeMessageTemplate(tpl, data)
+	if err != nil {
+		b.log.Printf("Error rendering the default template for chat %d: %s\n", msg.ChatId, err.Error())
+		return b.escapeHTMLEntities(msg.Post.Title + "\n" + msg.Post.Link)
+	}
 	return out
 }
 
@@ -228,8 +391,28 @@ func (b *RSSBot) registerCommands() (err error) {
 	b.bot.Handle("/start", b.handleStart)
 	b.bot.Handle("/help", b.handleHelp)
 	b.bot.Handle("/add", b.handleAdd)
+	b.bot.Handle("/preview", b.handlePreview)
 	b.bot.Handle("/list", b.handleList)
 	b.bot.Handle("/remove", b.handleRemove)
+	b.bot.Handle("/filter", b.handleFilter)
+	b.bot.Handle("/interval", b.handleInterval)
+	b.bot.Handle("/backfill", b.handleBackfill)
+	b.bot.Handle("/delivery", b.handleDelivery)
+	b.bot.Handle("/timezone", b.handleTimezone)
+	b.bot.Handle("/quiet", b.handleQuiet)
+	b.bot.Handle("/template", b.handleTemplate)
+	b.bot.Handle("/summary", b.handleSummary)
+	b.bot.Handle("/images", b.handleImages)
+	b.bot.Handle("/metadata", b.handleMetadata)
+	b.bot.Handle("/export", b.handleExport)
+	b.bot.Handle("/import", b.handleImport)
+	b.bot.Handle(tb.OnDocument, b.handleDocument)
+
+	// When a group is upgraded to a supergroup, move the subscriptions to the new chat
+	b.bot.Handle(tb.OnMigration, func(from, to int64) {
+		// Error is already logged
+		_ = b.feeds.MigrateChat(from, to)
+	})
 
 	// Handler for callbacks
 	b.bot.Handle(tb.OnCallback, func(cb *tb.Callback) {
@@ -255,19 +438,70 @@ func (b *RSSBot) registerCommands() (err error) {
 		// Confirm removing a feed
 		case "confirm-remove":
 			b.callbackConfirmRemove(cb, userData)
+
+		// Choose one of the feeds found on a web page
+		case "add-feed":
+			b.callbackAddFeed(cb, userData)
 		}
 	})
 
 	// Set commands for Telegram
 	err = b.bot.SetCommands([]tb.Command{
 		{Text: "add", Description: "Subscribe to a new feed"},
+		{Text: "preview", Description: "Show the last posts of a feed before subscribing"},
 		{Text: "list", Description: "List subscriptions for this chat"},
 		{Text: "remove", Description: "Unsubscribe from a feed"},
+		{Text: "filter", Description: "Filter the posts sent for a subscription"},
+		{Text: "interval", Description: "Set how often a feed is fetched"},
+		{Text: "backfill", Description: "Set how many past posts are sent when subscribing"},
+		{Text: "delivery", Description: "Receive posts instantly or in a digest"},
+		{Text: "timezone", Description: "Set the time zone for digests and quiet hours"},
+		{Text: "quiet", Description: "Set the quiet hours for this chat"},
+		{Text: "template", Description: "Customize how messages look"},
+		{Text: "summary", Description: "Include the summary of posts in messages"},
+		{Text: "images", Description: "Include the image of posts in messages"},
+		{Text: "metadata", Description: "Set how the web page of posts is used"},
+		{Text: "export", Description: "Export subscriptions as an OPML file"},
+		{Text: "import", Description: "Import subscriptions from an OPML file"},
 		{Text: "help", Description: "Show help message"},
 	})
 	return err
 }
 
+// Returns the poller to use, which is either a webhook or a long poller depending on the configuration
+func (b *RSSBot) getPoller() (tb.Poller, error) {
+	publicURL := viper.GetString("WebhookURL")
+	if publicURL == "" {
+		b.webhook = nil
+		return &tb.LongPoller{Timeout: 10 * time.Second}, nil
+	}
+
+	// Validate the options
+	listen := viper.GetString("WebhookListen")
+	if listen == "" {
+		return nil, errors.New("Webhook listen address not set. Please make sure that the 'WebhookListen' option is present in the config file, or use the 'BOT_WEBHOOKLISTEN' environmental variable.")
+	}
+	secretToken := viper.GetString("WebhookSecretToken")
+	if secretToken != "" && !webhookSecretMatch.MatchString(secretToken) {
+		return nil, errors.New("Invalid webhook secret token: it must be between 1 and 256 characters, and it can only contain the characters A-Z, a-z, 0-9, _ and -")
+	}
+	tlsCert := viper.GetString("WebhookTLSCert")
+	tlsKey := viper.GetString("WebhookTLSKey")
+	if (tlsCert == "") != (tlsKey == "") {
+		return nil, errors.New("Both 'WebhookTLSCert' and 'WebhookTLSKey' must be set to enable TLS for the webhook")
+	}
+
+	b.webhook = &webhookPoller{
+		Listen:      listen,
+		PublicURL:   publicURL,
+		SecretToken: secretToken,
+		TLSCert:     tlsCert,
+		TLSKey:      tlsKey,
+		log:         b.log,
+	}
+	return b.webhook, nil
+}
+
 // Returns the list of allowed users (if any)
 // Returns a map so lookups are faster
 func (b *RSSBot) getAllowedUsers() (allowedUsers map[int64]bool) {
@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Kinds of errors related to the lifecycle of a chat
+type chatErrorKind int
+
+const (
+	// The error is not related to the chat's lifecycle
+	chatErrorNone chatErrorKind = iota
+	// The chat can't be reached anymore, for example because the bot was blocked or the chat was deleted
+	chatErrorGone
+	// The group was upgraded to a supergroup, which has a new ID
+	chatErrorMigrated
+)
+
+// Errors that mean that the chat can't be reached anymore
+var chatGoneErrors = []error{
+	tb.ErrBlockedByUser,
+	tb.ErrUserIsDeactivated,
+	tb.ErrNotStartedByUser,
+	tb.ErrChatNotFound,
+	tb.ErrBotKickedFromGroup,
+	tb.ErrBotKickedFromSuperGroup,
+	// telebot returns this error when the bot was kicked from a (non-super) group
+	tb.ErrKickingChatOwner,
+}
+
+// Messages of errors that mean that the chat can't be reached anymore, for errors telebot doesn't know about
+var chatGoneMessages = []string{
+	"bot was blocked by the user",
+	"bot was kicked from",
+	"bot is not a member of",
+	"group chat was deleted",
+	"chat not found",
+	"user is deactivated",
+}
+
+// Classifies an error returned when sending a message to a chat
+// For chats that were migrated, it returns the new chat ID too
+func classifyChatError(err error) (chatErrorKind, int64) {
+	if err == nil {
+		return chatErrorNone, 0
+	}
+
+	// Check for migrated groups
+	// telebot sets the "migrate_to_chat_id" parameter on the error
+	if errors.Is(err, tb.ErrGroupMigrated) {
+		apiErr := &tb.APIError{}
+		if errors.As(err, &apiErr) && apiErr.Parameters != nil {
+			newId, err := strconv.ParseInt(fmt.Sprint(apiErr.Parameters["migrate_to_chat_id"]), 10, 64)
+			if err == nil && newId != 0 {
+				return chatErrorMigrated, newId
+			}
+		}
+		return chatErrorNone, 0
+	}
+
+	for _, e := range chatGoneErrors {
+		if errors.Is(err, e) {
+			return chatErrorGone, 0
+		}
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range chatGoneMessages {
+		if strings.Contains(msg, m) {
+			return chatErrorGone, 0
+		}
+	}
+
+	return chatErrorNone, 0
+}
+
+// Handles errors related to the lifecycle of a chat
+// Returns the kind of error and, for migrated chats, the new chat ID
+func (b *RSSBot) handleChatError(chatId int64, err error) (chatErrorKind, int64) {
+	kind, newChatId := classifyChatError(err)
+	switch kind {
+	case chatErrorGone:
+		// Remove all subscriptions for the chat
+		b.log.Printf("Chat %d can't be reached anymore (%s); removing its subscriptions\n", chatId, err)
+		err = b.feeds.DeleteChat(chatId)
+		if err != nil {
+			// Error is already logged
+			return chatErrorNone, 0
+		}
+	case chatErrorMigrated:
+		// Move the subscriptions to the new chat
+		b.log.Printf("Chat %d was migrated to %d\n", chatId, newChatId)
+		err = b.feeds.MigrateChat(chatId, newChatId)
+		if err != nil {
+			// Error is already logged
+			return chatErrorNone, 0
+		}
+	}
+	return kind, newChatId
+}
@@ -1,44 +1,225 @@
 package bot
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ItalyPaleAle/rss-bot/feeds"
 	tb "gopkg.in/tucnak/telebot.v2"
 )
 
+// Time after which the choice between multiple feeds found on a web page expires
+const feedChoiceExpiration = time.Hour
+
+// List of feeds found on a web page, waiting for the user to choose one
+type feedChoice struct {
+	Urls     []string
+	Backfill int
+	Expires  time.Time
+}
+
+// Usage message for the /add command
+const addUsage = "Invalid arguments: need \"/add <url> [count]\", where count is the number of recent posts to send (up to %d)"
+
 // Handles /add commands
 func (b *RSSBot) handleAdd(m *tb.Message) {
 	// Get args
 	args := GetArgs(m.Payload)
-	if len(args) != 1 {
-		b.respondToCommand(m, "Invalid arguments: need \"/add <url>\"")
+	if len(args) < 1 || len(args) > 2 {
+		b.respondToCommand(m, fmt.Sprintf(addUsage, feeds.MaxBackfill))
 		return
 	}
 	url := args[0]
 	if url == "" {
-		b.respondToCommand(m, "Invalid arguments: need \"/add <url>\"")
+		b.respondToCommand(m, fmt.Sprintf(addUsage, feeds.MaxBackfill))
 		return
 	}
 
+	// Number of posts to send; if not set, use the chat's default
+	backfill := -1
+	if len(args) == 2 {
+		var err error
+		backfill, err = strconv.Atoi(args[1])
+		if err != nil || backfill < 0 || backfill > feeds.MaxBackfill {
+			b.respondToCommand(m, fmt.Sprintf(addUsage, feeds.MaxBackfill))
+			return
+		}
+	}
+
 	// Send a message that we're working on it
 	wm, _ := b.respondToCommand(m, "Working on it…")
 
 	// Add the subscription
-	post, err := b.feeds.AddSubscription(url, m.Chat.ID)
+	b.addSubscription(wm, url, m.Chat.ID, backfill)
+}
+
+// Handles the callbacks with "add-feed" action, sent when choosing one of the feeds found on a web page
+func (b *RSSBot) callbackAddFeed(cb *tb.Callback, userData string) {
+	// Get the list of feeds and the index of the one that was chosen
+	var (
+		url      string
+		backfill int
+	)
+	parts := strings.SplitN(userData, "/", 2)
+	if len(parts) == 2 {
+		i, err := strconv.Atoi(parts[1])
+		b.choicesLock.Lock()
+		choice, ok := b.choices[parts[0]]
+		if ok && err == nil && i >= 0 && i < len(choice.Urls) && time.Now().Before(choice.Expires) {
+			url = choice.Urls[i]
+			backfill = choice.Backfill
+			delete(b.choices, parts[0])
+		}
+		b.choicesLock.Unlock()
+	}
+	if url == "" {
+		b.bot.Edit(cb.Message, "This request has expired, please run /add again")
+		return
+	}
+
+	b.bot.Edit(cb.Message, "Working on it…")
+	b.addSubscription(cb.Message, url, cb.Message.Chat.ID, backfill)
+}
+
+// Subscribes the chat to a feed, updating the message wm with the result
+// The last posts are delivered to the chat through the outbox
+func (b *RSSBot) addSubscription(wm *tb.Message, url string, chatId int64, backfill int) {
+	feed, queued, err := b.feeds.AddSubscription(url, chatId, backfill)
 	if err != nil {
-		if err == feeds.ErrAlreadySubscribed {
-			b.bot.Edit(wm, "This chat is already subscribed to the feed")
-		} else {
-			b.bot.Edit(wm, "An internal error occurred")
+		// If the URL is a web page with multiple feeds, ask which one to add
+		var discoveryErr *feeds.FeedDiscoveryError
+		if errors.As(err, &discoveryErr) && len(discoveryErr.Candidates) > 1 {
+			b.askFeedChoice(wm, discoveryErr.Candidates, backfill)
+			return
 		}
+		b.bot.Edit(wm, addErrorMessage(err), &tb.SendOptions{
+			DisableWebPagePreview: true,
+		})
 		return
 	}
 
-	b.bot.Edit(wm, fmt.Sprintf("The feed with URL %s was successfully added to this channel. Here is the last post published:", url), &tb.SendOptions{
+	out := fmt.Sprintf("The feed with URL %s was successfully added to this channel.", feed.Url)
+	switch {
+	case queued == 1:
+		out += " Here is the last post published:"
+	case queued > 1:
+		out += fmt.Sprintf(" Here are the last %d posts published:", queued)
+	}
+	b.bot.Edit(wm, out, &tb.SendOptions{
 		DisableWebPagePreview: true,
 	})
-	b.sendFeedUpdate(m.Sender, &feeds.UpdateMessage{
-		Post: *post,
+}
+
+// Asks the user which of the feeds found on a web page to subscribe to, with an inline keyboard
+func (b *RSSBot) askFeedChoice(wm *tb.Message, candidates []feeds.FeedCandidate, backfill int) {
+	// Callback data is limited to 64 bytes, so the URLs are stored here and buttons contain a random ID
+	idBytes := make([]byte, 6)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		b.log.Println("Error generating a random ID:", err)
+		b.bot.Edit(wm, "An internal error occurred")
+		return
+	}
+	id := hex.EncodeToString(idBytes)
+
+	choice := &feedChoice{
+		Urls:     make([]string, len(candidates)),
+		Backfill: backfill,
+		Expires:  time.Now().Add(feedChoiceExpiration),
+	}
+	keyboard := make([][]tb.InlineButton, 0, len(candidates)+1)
+	for i, c := range candidates {
+		choice.Urls[i] = c.Url
+		text := c.Url
+		if c.Title != "" {
+			text = c.Title + " (" + c.Url + ")"
+		}
+		keyboard = append(keyboard, []tb.InlineButton{
+			{Text: text, Data: fmt.Sprintf("add-feed/%s/%d", id, i)},
+		})
+	}
+	keyboard = append(keyboard, []tb.InlineButton{
+		{Text: "Cancel", Unique: "cancel"},
+	})
+
+	// Store the choice, removing expired ones
+	b.choicesLock.Lock()
+	now := time.Now()
+	for k, v := range b.choices {
+		if now.After(v.Expires) {
+			delete(b.choices, k)
+		}
+	}
+	b.choices[id] = choice
+	b.choicesLock.Unlock()
+
+	b.bot.Edit(wm, "I found multiple feeds on this page. Which one do you want to subscribe to?", &tb.SendOptions{
+		ReplyMarkup: &tb.ReplyMarkup{
+			InlineKeyboard: keyboard,
+		},
+		DisableWebPagePreview: true,
 	})
 }
+
+// Returns the message for the user explaining why a feed couldn't be added
+func addErrorMessage(err error) string {
+	var (
+		fetchErr     *feeds.FetchError
+		discoveryErr *feeds.FeedDiscoveryError
+	)
+	switch {
+	case err == feeds.ErrAlreadySubscribed:
+		return "This chat is already subscribed to the feed"
+	case errors.As(err, &discoveryErr):
+		if len(discoveryErr.Candidates) > 1 {
+			return "The URL is a web page that links to multiple feeds: add one of them with /add"
+		}
+		return "The URL is a web page, and I couldn't find a feed on it. Please check the address, or look for a link to the RSS or Atom feed on the page."
+	case errors.As(err, &fetchErr):
+		// Handled below
+	default:
+		return "An internal error occurred"
+	}
+
+	switch fetchErr.Kind {
+	case feeds.FetchErrorInvalidURL:
+		return "The URL is not valid: it must start with http:// or https://"
+	case feeds.FetchErrorDNS:
+		return "I couldn't find the server: please check that the address is spelled correctly"
+	case feeds.FetchErrorTimeout:
+		return "The server took too long to respond: please try again later"
+	case feeds.FetchErrorTLS:
+		return "I couldn't establish a secure connection to the server, because its TLS certificate is not valid"
+	case feeds.FetchErrorParse:
+		if fetchErr.Line > 0 {
+			return fmt.Sprintf("The feed is malformed and I couldn't read it (error at line %d). You may want to let the site's owner know.", fetchErr.Line)
+		}
+		return "The feed is malformed and I couldn't read it. You may want to let the site's owner know."
+	case feeds.FetchErrorNoItems:
+		return "The feed doesn't contain any post with a valid title and date, so I can't follow it"
+	case feeds.FetchErrorHTTPStatus:
+		status := fmt.Sprintf("%d %s", fetchErr.StatusCode, http.StatusText(fetchErr.StatusCode))
+		switch {
+		case fetchErr.StatusCode == http.StatusNotFound:
+			return fmt.Sprintf("The server responded with \"%s\": please check that the address is correct", status)
+		case fetchErr.StatusCode == http.StatusGone:
+			return fmt.Sprintf("The server responded with \"%s\": the feed doesn't exist anymore", status)
+		case fetchErr.StatusCode == http.StatusUnauthorized || fetchErr.StatusCode == http.StatusForbidden:
+			return fmt.Sprintf("The server responded with \"%s\": the feed may be private, or the site may be blocking bots", status)
+		case fetchErr.StatusCode == http.StatusTooManyRequests:
+			return fmt.Sprintf("The server responded with \"%s\": please try again later", status)
+		case fetchErr.StatusCode >= 500:
+			return fmt.Sprintf("The server responded with \"%s\", which is an error on the site's side: please try again later", status)
+		default:
+			return fmt.Sprintf("The server responded with \"%s\"", status)
+		}
+	default:
+		return fmt.Sprintf("I couldn't fetch the feed: %s", fetchErr)
+	}
+}
@@ -0,0 +1,54 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ItalyPaleAle/rss-bot/feeds"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Handles /backfill commands
+func (b *RSSBot) handleBackfill(m *tb.Message) {
+	// Get args
+	args := GetArgs(m.Payload)
+	if len(args) > 1 {
+		b.respondToCommand(m, "Invalid arguments: need \"/backfill [<count>|default]\"")
+		return
+	}
+
+	// If there's no count, show the current one
+	if len(args) == 0 {
+		chat, err := b.feeds.GetChat(m.Chat.ID)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		b.respondToCommand(m, fmt.Sprintf("When subscribing to a feed, I send the last %d posts to this chat. You can choose a different number for a single feed with \"/add <url> <count>\".", chat.Backfill))
+		return
+	}
+
+	// Parse the count
+	backfill := feeds.DefaultBackfill
+	if strings.ToLower(args[0]) != "default" {
+		var err error
+		backfill, err = strconv.Atoi(args[0])
+		if err != nil || backfill < 0 || backfill > feeds.MaxBackfill {
+			b.respondToCommand(m, fmt.Sprintf("Invalid count: it must be between 0 and %d", feeds.MaxBackfill))
+			return
+		}
+	}
+
+	// Set the count
+	err := b.feeds.SetChatBackfill(m.Chat.ID, backfill)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+	if backfill == 0 {
+		b.respondToCommand(m, "Done, I won't send any past post when this chat subscribes to a feed")
+	} else {
+		b.respondToCommand(m, fmt.Sprintf("Done, I'll send the last %d posts when this chat subscribes to a feed", backfill))
+	}
+}
@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ItalyPaleAle/rss-bot/feeds"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Usage message for the /delivery command
+const deliveryUsage = `Invalid arguments. Usage:
+/delivery <id> - Show how posts are delivered for a subscription
+/delivery <id> instant - Send each post as soon as it's published
+/delivery <id> hourly - Send a digest every hour
+/delivery <id> daily [HH:MM] - Send a digest every day (default: 08:00)
+/delivery <id> weekly [day] [HH:MM] - Send a digest every week (default: Monday at 08:00)`
+
+// Handles /delivery commands
+func (b *RSSBot) handleDelivery(m *tb.Message) {
+	// Get args
+	args := GetArgs(m.Payload)
+	if len(args) < 1 {
+		b.respondToCommand(m, deliveryUsage)
+		return
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id < 1 {
+		b.respondToCommand(m, deliveryUsage)
+		return
+	}
+
+	// Get the list of subscriptions
+	subs, err := b.feeds.ListSubscriptions(m.Chat.ID)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+
+	// Check if the feed exists
+	if id > len(subs) {
+		b.respondToCommand(m, "Subscription not found")
+		return
+	}
+	feed := subs[id-1]
+
+	// If there's no mode, show the current one
+	if len(args) == 1 {
+		mode, next, err := b.feeds.GetSubscriptionDelivery(feed.ID, m.Chat.ID)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		b.respondToCommand(m, fmt.Sprintf("Posts of the feed %s are %s", feed.Url, b.formatDeliveryMode(m.Chat.ID, mode, next)), &tb.SendOptions{
+			DisableWebPagePreview: true,
+		})
+		return
+	}
+
+	// Parse the mode
+	mode, err := feeds.ParseDeliveryMode(strings.Join(args[1:], " "))
+	if err != nil {
+		b.respondToCommand(m, deliveryUsage)
+		return
+	}
+
+	// Set the mode
+	err = b.feeds.SetSubscriptionDelivery(feed.ID, m.Chat.ID, mode)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+	_, next, err := b.feeds.GetSubscriptionDelivery(feed.ID, m.Chat.ID)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+	b.respondToCommand(m, fmt.Sprintf("Done, posts of the feed %s are now %s", feed.Url, b.formatDeliveryMode(m.Chat.ID, mode, next)), &tb.SendOptions{
+		DisableWebPagePreview: true,
+	})
+}
+
+// Returns a description of the delivery mode, with the time of the next digest in the chat's time zone
+func (b *RSSBot) formatDeliveryMode(chatId int64, mode feeds.DeliveryMode, next time.Time) string {
+	if !mode.IsDigest() {
+		return "sent as soon as they're published"
+	}
+
+	// Get the chat's time zone
+	loc := time.UTC
+	chat, err := b.feeds.GetChat(chatId)
+	if err == nil {
+		if l, err := time.LoadLocation(chat.Timezone); err == nil {
+			loc = l
+		}
+	}
+
+	return fmt.Sprintf("collected in a digest (%s); the next one will be sent on %s", mode, next.In(loc).Format("Mon, 02 Jan 2006 15:04 MST"))
+}
+
+// Handles /timezone commands
+func (b *RSSBot) handleTimezone(m *tb.Message) {
+	// Get args
+	args := GetArgs(m.Payload)
+	if len(args) > 1 {
+		b.respondToCommand(m, "Invalid arguments: need \"/timezone [<name>]\", for example \"/timezone Europe/Rome\"")
+		return
+	}
+
+	// If there's no time zone, show the current one
+	if len(args) == 0 {
+		chat, err := b.feeds.GetChat(m.Chat.ID)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		b.respondToCommand(m, fmt.Sprintf("The time zone of this chat is %s", chat.Timezone))
+		return
+	}
+
+	// Validate the time zone
+	_, err := time.LoadLocation(args[0])
+	if err != nil || args[0] == "" || strings.ToLower(args[0]) == "local" {
+		b.respondToCommand(m, "Invalid time zone: use a name from the tz database, for example \"Europe/Rome\" or \"America/New_York\"")
+		return
+	}
+
+	// Set the time zone
+	err = b.feeds.SetChatTimezone(m.Chat.ID, args[0])
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+	b.respondToCommand(m, fmt.Sprintf("Done, the time zone of this chat is now %s", args[0]))
+}
@@ -0,0 +1,150 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ItalyPaleAle/rss-bot/feeds"
+	"github.com/ItalyPaleAle/rss-bot/models"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Usage message for the /filter command
+const filterUsage = `Invalid arguments. Usage:
+/filter <id> - Show the filters for a subscription
+/filter <id> include [title|link|category|author] <keyword or /regex/> - Only send posts that match
+/filter <id> exclude [title|link|category|author] <keyword or /regex/> - Don't send posts that match
+/filter <id> delete <n> - Remove a filter
+/filter <id> clear - Remove all filters`
+
+// Handles /filter commands
+func (b *RSSBot) handleFilter(m *tb.Message) {
+	// Get args
+	args := GetArgs(m.Payload)
+	if len(args) < 1 {
+		b.respondToCommand(m, filterUsage)
+		return
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id < 1 {
+		b.respondToCommand(m, filterUsage)
+		return
+	}
+
+	// Get the list of subscriptions
+	subs, err := b.feeds.ListSubscriptions(m.Chat.ID)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+
+	// Check if the feed exists
+	if id > len(subs) {
+		b.respondToCommand(m, "Subscription not found")
+		return
+	}
+	feed := subs[id-1]
+
+	// If there's no action, list the filters
+	if len(args) == 1 {
+		b.listFilters(m, &feed)
+		return
+	}
+
+	switch strings.ToLower(args[1]) {
+	case models.FilterActionInclude, models.FilterActionExclude:
+		if len(args) < 3 {
+			b.respondToCommand(m, filterUsage)
+			return
+		}
+
+		// The field is optional
+		field := ""
+		rest := args[2:]
+		if len(rest) > 1 {
+			switch strings.ToLower(rest[0]) {
+			case models.FilterFieldTitle, models.FilterFieldLink, models.FilterFieldCategory, models.FilterFieldAuthor:
+				field = strings.ToLower(rest[0])
+				rest = rest[1:]
+			}
+		}
+
+		filter, err := feeds.NewFilter(strings.ToLower(args[1]), field, strings.Join(rest, " "))
+		if err != nil {
+			b.respondToCommand(m, fmt.Sprintf("Invalid filter: %s", err))
+			return
+		}
+		err = b.feeds.AddFilter(feed.ID, m.Chat.ID, filter)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		b.respondToCommand(m, "Done, I've added the filter")
+
+	case "delete":
+		if len(args) != 3 {
+			b.respondToCommand(m, filterUsage)
+			return
+		}
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 1 {
+			b.respondToCommand(m, filterUsage)
+			return
+		}
+		filters, err := b.feeds.ListFilters(feed.ID, m.Chat.ID)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		if n > len(filters) {
+			b.respondToCommand(m, "Filter not found")
+			return
+		}
+		err = b.feeds.DeleteFilter(feed.ID, m.Chat.ID, filters[n-1].ID)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		b.respondToCommand(m, "Done, I've removed the filter")
+
+	case "clear":
+		err = b.feeds.ClearFilters(feed.ID, m.Chat.ID)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		b.respondToCommand(m, "Done, I've removed all filters")
+
+	default:
+		b.respondToCommand(m, filterUsage)
+	}
+}
+
+// Responds with the list of filters for a subscription
+func (b *RSSBot) listFilters(m *tb.Message, feed *models.Feed) {
+	filters, err := b.feeds.ListFilters(feed.ID, m.Chat.ID)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+
+	if len(filters) == 0 {
+		b.respondToCommand(m, fmt.Sprintf("There are no filters for the feed %s: all posts are sent", feed.Url), &tb.SendOptions{
+			DisableWebPagePreview: true,
+		})
+		return
+	}
+
+	out := fmt.Sprintf("Here's the list of filters for the feed %s:\n", feed.Url)
+	for i, f := range filters {
+		pattern := fmt.Sprintf("\"%s\"", f.Pattern)
+		if f.Regex {
+			pattern = fmt.Sprintf("/%s/", f.Pattern)
+		}
+		out += fmt.Sprintf("%d: %s %s in %s\n", (i + 1), f.Action, pattern, f.Field)
+	}
+	b.respondToCommand(m, out, &tb.SendOptions{
+		DisableWebPagePreview: true,
+	})
+}
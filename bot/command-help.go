@@ -9,8 +9,21 @@ func (b *RSSBot) handleHelp(m *tb.Message) {
 	// Send the help message
 	b.bot.Send(m.Sender, `
 Avaliable commands:
-/add <URL> - Subscribe to a new feed for this channel
+/add <URL> [count] - Subscribe to a new feed for this channel and send the last posts; if the URL is a web page, I will look for its feeds
+/preview <URL> [n] - Show the last n posts of a feed (default: 3), without subscribing to it
 /list - List all subscribed feeds for this channel
 /delete <ID> - Remove a feed subscription
+/filter <ID> [include|exclude] [field] <keyword or /regex/> - Show or add filters for a feed subscription
+/interval <ID> [<duration>|default] - Show or set how often a feed is fetched, e.g. "30m", "2h" or "1d"
+/delivery <ID> [instant|hourly|daily [HH:MM]|weekly [day] [HH:MM]] - Show or set whether posts are sent instantly or collected in a digest
+/timezone [<name>] - Show or set the time zone used for digests and quiet hours, e.g. "Europe/Rome"
+/quiet [<HH:MM-HH:MM> [timezone]|hold|silent|off] - Show or set the quiet hours, during which posts are held until they end or sent without a notification
+/template [<ID>] [<preset>|<template>|reset] - Show or set the template for messages in this chat, or for a single subscription
+/summary [on|off|<length>] - Show or set whether messages include the summary of posts, and its maximum length (default: off)
+/images [on|off] - Show or set whether messages include the image of posts (default: on)
+/metadata <ID> [always|fallback|never|title <feed|opengraph|longer>|description <on|off>] - Show or set when the web page of a feed's posts is requested, and whether its title and description are used for posts
+/backfill [<count>|default] - Show or set how many past posts are sent when subscribing to a feed (default: 1)
+/export - Export all subscribed feeds for this channel as an OPML file
+/import - Subscribe to all feeds in an OPML file (send it with /import as caption)
 `)
 }
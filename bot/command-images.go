@@ -0,0 +1,45 @@
+package bot
+
+import (
+	"strings"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Handles /images commands
+func (b *RSSBot) handleImages(m *tb.Message) {
+	// Get args
+	args := GetArgs(m.Payload)
+	if len(args) > 1 || (len(args) == 1 && strings.ToLower(args[0]) != "on" && strings.ToLower(args[0]) != "off") {
+		b.respondToCommand(m, "Invalid arguments: need \"/images [on|off]\"")
+		return
+	}
+
+	// If there's no argument, show the current setting
+	if len(args) == 0 {
+		chat, err := b.feeds.GetChat(m.Chat.ID)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		if chat.Images {
+			b.respondToCommand(m, "Messages in this chat include the image of posts, if any. Disable them with \"/images off\".")
+		} else {
+			b.respondToCommand(m, "Messages in this chat don't include the image of posts. Enable them with \"/images on\".")
+		}
+		return
+	}
+
+	// Set the option
+	images := strings.ToLower(args[0]) == "on"
+	err := b.feeds.SetChatImages(m.Chat.ID, images)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+	if images {
+		b.respondToCommand(m, "Done, messages in this chat will include the image of posts")
+	} else {
+		b.respondToCommand(m, "Done, messages in this chat won't include the image of posts")
+	}
+}
@@ -0,0 +1,79 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ItalyPaleAle/rss-bot/feeds"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Handles /interval commands
+func (b *RSSBot) handleInterval(m *tb.Message) {
+	// Get args
+	args := GetArgs(m.Payload)
+	if len(args) < 1 || len(args) > 2 {
+		b.respondToCommand(m, "Invalid arguments: need \"/interval <id> [<duration>|default]\"")
+		return
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id < 1 {
+		b.respondToCommand(m, "Invalid arguments: need \"/interval <id> [<duration>|default]\"")
+		return
+	}
+
+	// Get the list of subscriptions
+	subs, err := b.feeds.ListSubscriptions(m.Chat.ID)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+
+	// Check if the feed exists
+	if id > len(subs) {
+		b.respondToCommand(m, "Subscription not found")
+		return
+	}
+	feed := subs[id-1]
+
+	// If there's no duration, show the current interval
+	if len(args) == 1 {
+		sub, current, err := b.feeds.GetSubscriptionInterval(feed.ID, m.Chat.ID)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		pref := "default"
+		if sub > 0 {
+			pref = FormatInterval(sub)
+		}
+		b.respondToCommand(m, fmt.Sprintf("The feed %s is fetched every %s (preference for this chat: %s)", feed.Url, FormatInterval(current), pref), &tb.SendOptions{
+			DisableWebPagePreview: true,
+		})
+		return
+	}
+
+	// Parse the duration
+	var interval time.Duration
+	if strings.ToLower(args[1]) != "default" {
+		interval, err = ParseInterval(args[1])
+		if err != nil || interval < feeds.MinFetchInterval || interval > feeds.MaxFetchInterval {
+			b.respondToCommand(m, fmt.Sprintf("Invalid duration: it must be between %s and %s, for example \"30m\", \"2h\" or \"1d\"", FormatInterval(feeds.MinFetchInterval), FormatInterval(feeds.MaxFetchInterval)))
+			return
+		}
+	}
+
+	// Set the interval
+	err = b.feeds.SetSubscriptionInterval(feed.ID, m.Chat.ID, interval)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+	if interval == 0 {
+		b.respondToCommand(m, "Done, this chat will use the default interval for the feed")
+	} else {
+		b.respondToCommand(m, fmt.Sprintf("Done, this chat would like the feed to be fetched every %s. If other chats are subscribed to the same feed, the shortest interval is used.", FormatInterval(interval)))
+	}
+}
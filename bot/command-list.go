@@ -3,6 +3,7 @@ package bot
 import (
 	"fmt"
 
+	"github.com/ItalyPaleAle/rss-bot/models"
 	tb "gopkg.in/tucnak/telebot.v2"
 )
 
@@ -23,7 +24,22 @@ func (b *RSSBot) handleList(m *tb.Message) {
 
 	out := "Here's the list of feeds this chat is subscribed to:\n"
 	for i, f := range feeds {
-		out += fmt.Sprintf("%d: %s\n", (i + 1), f.Url)
+		out += fmt.Sprintf("%d: %s%s\n", (i + 1), f.Url, b.formatFeedHealth(&f))
+	}
+	b.respondToCommand(m, out, &tb.SendOptions{
+		DisableWebPagePreview: true,
+	})
+}
+
+// Returns a string with the health status of a feed, to be appended to its URL
+// This is empty for feeds that are working
+func (b *RSSBot) formatFeedHealth(f *models.Feed) string {
+	switch {
+	case f.Paused:
+		return fmt.Sprintf(" ⏸ paused (last error: %s)", f.LastError)
+	case f.Failures > 0:
+		return fmt.Sprintf(" ⚠️ failing since %s (last error: %s)", f.LastSuccess.UTC().Format("02 Jan 2006 15:04 MST"), f.LastError)
+	default:
+		return ""
 	}
-	b.respondToCommand(m, out)
 }
@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ItalyPaleAle/rss-bot/feeds"
+	"github.com/ItalyPaleAle/rss-bot/models"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Usage message for the /metadata command
+const metadataUsage = `Usage:
+/metadata <id> - Show the options for the feed
+/metadata <id> always|fallback|never - Set when the web page of posts is requested: always, only if the feed doesn't include an image or the options below need it (default), or never
+/metadata <id> title feed|opengraph|longer - Set which title is used for posts: the one in the feed (default), the one in the web page's OpenGraph tags, or the longer of the two
+/metadata <id> description on|off - Set whether the description in the web page's OpenGraph tags is used as summary for posts that don't have one (default: off)
+These options apply to all chats subscribed to the same feed.`
+
+// Descriptions of the modes for requesting the metadata of posts
+var metadataModeDescriptions = map[string]string{
+	models.FeedMetadataAlways:   "the web page of each post is always requested",
+	models.FeedMetadataFallback: "the web page of each post is requested only if the feed doesn't include an image for it, or if it's needed for the title or summary",
+	models.FeedMetadataNever:    "the web page of posts is never requested, and only the data in the feed is used",
+}
+
+// Descriptions of the title policies
+var titlePolicyDescriptions = map[string]string{
+	models.FeedTitleFeed:      "the title in the feed",
+	models.FeedTitleOpenGraph: "the title in the web page's OpenGraph tags",
+	models.FeedTitleLonger:    "the longer between the title in the feed and the one in the web page's OpenGraph tags",
+}
+
+// Handles /metadata commands
+func (b *RSSBot) handleMetadata(m *tb.Message) {
+	// Get args
+	args := GetArgs(m.Payload)
+	if len(args) < 1 || len(args) > 3 {
+		b.respondToCommand(m, "Invalid arguments\n\n"+metadataUsage)
+		return
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id < 1 {
+		b.respondToCommand(m, "Invalid arguments\n\n"+metadataUsage)
+		return
+	}
+
+	// Get the list of subscriptions
+	subs, err := b.feeds.ListSubscriptions(m.Chat.ID)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+
+	// Check if the feed exists
+	if id > len(subs) {
+		b.respondToCommand(m, "Subscription not found")
+		return
+	}
+	feed := subs[id-1]
+
+	// If there's no option, show the current ones
+	if len(args) == 1 {
+		description := "not used"
+		if feed.OGDescription {
+			description = "used as summary for posts that don't have one"
+		}
+		out := fmt.Sprintf("Options for the feed %s:\n- Web page (%s): %s\n- Title (%s): %s\n- OpenGraph description: %s\n\n%s",
+			feed.Url,
+			feed.Metadata, metadataModeDescriptions[feed.Metadata],
+			feed.TitlePolicy, titlePolicyDescriptions[feed.TitlePolicy],
+			description,
+			metadataUsage,
+		)
+		b.respondToCommand(m, out, &tb.SendOptions{
+			DisableWebPagePreview: true,
+		})
+		return
+	}
+
+	// Set the option
+	var out string
+	switch strings.ToLower(args[1]) {
+	case "title":
+		if len(args) != 3 {
+			b.respondToCommand(m, "Invalid arguments: need \"/metadata <id> title feed|opengraph|longer\"")
+			return
+		}
+		policy, err := feeds.ParseTitlePolicy(args[2])
+		if err != nil {
+			b.respondToCommand(m, "Invalid title: it must be \"feed\", \"opengraph\" or \"longer\"")
+			return
+		}
+		err = b.feeds.SetFeedTitlePolicy(feed.ID, policy)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		out = fmt.Sprintf("Done, posts of the feed %s will use %s.", feed.Url, titlePolicyDescriptions[policy])
+		if policy != models.FeedTitleFeed && feed.Metadata == models.FeedMetadataNever {
+			out += fmt.Sprintf(" Note that the web page of posts is never requested for this feed, so the title in the feed is used until you change that with \"/metadata %d fallback\".", id)
+		}
+	case "description":
+		if len(args) != 3 || (strings.ToLower(args[2]) != "on" && strings.ToLower(args[2]) != "off") {
+			b.respondToCommand(m, "Invalid arguments: need \"/metadata <id> description on|off\"")
+			return
+		}
+		enabled := strings.ToLower(args[2]) == "on"
+		err = b.feeds.SetFeedOGDescription(feed.ID, enabled)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		if enabled {
+			out = fmt.Sprintf("Done, posts of the feed %s that don't have a summary will use the description in the web page. Summaries are included in messages only if enabled with /summary.", feed.Url)
+		} else {
+			out = fmt.Sprintf("Done, posts of the feed %s won't use the description in the web page.", feed.Url)
+		}
+	default:
+		if len(args) != 2 {
+			b.respondToCommand(m, "Invalid arguments\n\n"+metadataUsage)
+			return
+		}
+		mode, err := feeds.ParseMetadataMode(args[1])
+		if err != nil {
+			b.respondToCommand(m, "Invalid mode: it must be \"always\", \"fallback\" or \"never\"")
+			return
+		}
+		err = b.feeds.SetFeedMetadata(feed.ID, mode)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		out = fmt.Sprintf("Done, for the feed %s %s.", feed.Url, metadataModeDescriptions[mode])
+	}
+
+	b.respondToCommand(m, out+" This applies to all chats subscribed to the same feed.", &tb.SendOptions{
+		DisableWebPagePreview: true,
+	})
+}
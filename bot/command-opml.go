@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ItalyPaleAle/rss-bot/feeds"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Maximum size of OPML files that can be imported
+const maxOPMLSize = 1 << 20
+
+// Maximum length of messages sent by the bot, in characters
+const maxMessageLength = 4096
+
+// Handles /export commands
+func (b *RSSBot) handleExport(m *tb.Message) {
+	// Get the list of subscriptions
+	subs, err := b.feeds.ListSubscriptions(m.Chat.ID)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+	if len(subs) == 0 {
+		b.respondToCommand(m, "This chat is not subscribed to any feed")
+		return
+	}
+
+	// Generate the OPML document and send it
+	data, err := feeds.ExportOPML("RSS bot subscriptions", subs)
+	if err != nil {
+		b.log.Println("Error generating OPML document:", err)
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+	b.respondToCommand(m, &tb.Document{
+		File:     tb.FromReader(bytes.NewReader(data)),
+		FileName: "subscriptions.opml",
+		MIME:     "text/x-opml",
+		Caption:  fmt.Sprintf("Here are the %d feeds this chat is subscribed to", len(subs)),
+	})
+}
+
+// Handles /import commands
+// The OPML file can be sent with "/import" as caption, or the command can be sent in reply to the file
+func (b *RSSBot) handleImport(m *tb.Message) {
+	doc := m.Document
+	if doc == nil && m.ReplyTo != nil {
+		doc = m.ReplyTo.Document
+	}
+	if doc == nil {
+		b.respondToCommand(m, "Send an OPML file with \"/import\" as caption, or reply to an OPML file with \"/import\"")
+		return
+	}
+	if doc.FileSize > maxOPMLSize {
+		b.respondToCommand(m, "The file is too big")
+		return
+	}
+
+	// Send a message that we're working on it
+	wm, _ := b.respondToCommand(m, "Working on it…")
+
+	// Download the file and parse it
+	reader, err := b.bot.GetFile(&doc.File)
+	if err != nil {
+		b.log.Println("Error downloading file:", err)
+		b.bot.Edit(wm, "An internal error occurred")
+		return
+	}
+	defer reader.Close()
+	urls, err := feeds.ParseOPML(io.LimitReader(reader, maxOPMLSize))
+	if err != nil {
+		b.bot.Edit(wm, fmt.Sprintf("The file is not a valid OPML document: %s", err))
+		return
+	}
+
+	// Subscribe to each feed
+	results := make([]string, len(urls))
+	added := 0
+	// No posts are sent for imported feeds, as there could be many
+	for i, url := range urls {
+		_, _, err = b.feeds.AddSubscription(url, m.Chat.ID, 0)
+		switch {
+		case err == nil:
+			results[i] = "✅ " + url
+			added++
+		case err == feeds.ErrAlreadySubscribed:
+			results[i] = "☑️ " + url + ": already subscribed"
+		default:
+			results[i] = "❌ " + url + ": " + addErrorMessage(err)
+		}
+	}
+
+	// Send the report, splitting it in multiple messages if needed
+	b.bot.Edit(wm, fmt.Sprintf("Subscribed to %d of the %d feeds in the file", added, len(urls)))
+	msg := ""
+	for _, r := range results {
+		if len(msg)+len(r)+1 > maxMessageLength {
+			b.respondToCommand(m, msg, &tb.SendOptions{
+				DisableWebPagePreview: true,
+			})
+			msg = ""
+		}
+		msg += r + "\n"
+	}
+	if strings.TrimSpace(msg) != "" {
+		b.respondToCommand(m, msg, &tb.SendOptions{
+			DisableWebPagePreview: true,
+		})
+	}
+}
+
+// Handles documents sent to the bot
+func (b *RSSBot) handleDocument(m *tb.Message) {
+	// Documents with "/import" as caption are OPML files to import
+	caption := strings.TrimSpace(m.Caption)
+	if caption == "/import" || strings.HasPrefix(caption, "/import ") || strings.HasPrefix(caption, "/import@") {
+		b.handleImport(m)
+	}
+}
@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/ItalyPaleAle/rss-bot/feeds"
+	"github.com/ItalyPaleAle/rss-bot/models"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Number of posts shown by /preview by default
+const defaultPreviewPosts = 3
+
+// Handles /preview commands
+func (b *RSSBot) handlePreview(m *tb.Message) {
+	// Get args
+	args := GetArgs(m.Payload)
+	if len(args) < 1 || len(args) > 2 || args[0] == "" {
+		b.respondToCommand(m, fmt.Sprintf("Invalid arguments: need \"/preview <url> [n]\", where n is the number of posts to show (up to %d)", feeds.MaxPreviewPosts))
+		return
+	}
+	n := defaultPreviewPosts
+	if len(args) == 2 {
+		var err error
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > feeds.MaxPreviewPosts {
+			b.respondToCommand(m, fmt.Sprintf("Invalid arguments: the number of posts must be between 1 and %d", feeds.MaxPreviewPosts))
+			return
+		}
+	}
+
+	// Send a message that we're working on it
+	wm, _ := b.respondToCommand(m, "Working on it…")
+
+	// Fetch the feed
+	preview, err := b.feeds.PreviewFeed(args[0], n)
+	if err != nil {
+		// If the URL is a web page with multiple feeds, list them
+		var discoveryErr *feeds.FeedDiscoveryError
+		msg := addErrorMessage(err)
+		if errors.As(err, &discoveryErr) && len(discoveryErr.Candidates) > 1 {
+			msg = "The URL is a web page that links to multiple feeds. Preview one of them with /preview:\n"
+			for _, c := range discoveryErr.Candidates {
+				msg += c.Url + "\n"
+			}
+		}
+		b.bot.Edit(wm, msg, &tb.SendOptions{
+			DisableWebPagePreview: true,
+		})
+		return
+	}
+
+	// Send the summary
+	out := fmt.Sprintf("🎙 %s\n🔗 %s\n\nValid posts: %d\n", preview.Feed.Title, preview.Feed.Url, preview.Count)
+	if preview.Feed.SkippedInvalidDate > 0 {
+		out += fmt.Sprintf("Skipped because of an invalid date: %d\n", preview.Feed.SkippedInvalidDate)
+	}
+	if preview.Feed.SkippedEmptyTitle > 0 {
+		out += fmt.Sprintf("Skipped because of an empty title: %d\n", preview.Feed.SkippedEmptyTitle)
+	}
+	if len(preview.Posts) > 0 {
+		out += fmt.Sprintf("\nHere are the last %d posts, as they would be sent:", len(preview.Posts))
+	}
+	b.bot.Edit(wm, out, &tb.SendOptions{
+		DisableWebPagePreview: true,
+	})
+
+	// Get the chat's settings, ignoring errors
+	chat, err := b.feeds.GetChat(m.Chat.ID)
+	if err != nil {
+		chat = &models.Chat{Images: true}
+	}
+
+	// Send the posts, from old to new
+	for _, p := range preview.Posts {
+		p.Summary = feeds.TruncateSummary(p.Summary, chat.SummaryLength)
+		if !chat.Images {
+			p.Photo = ""
+		}
+		err = b.limiter.Wait(b.ctx, m.Chat.ID)
+		if err != nil {
+			return
+		}
+		err = b.sendFeedUpdate(m.Chat, &feeds.UpdateMessage{
+			Feed:     preview.Feed,
+			Post:     p,
+			ChatId:   m.Chat.ID,
+			Template: chat.Template,
+			Timezone: chat.Timezone,
+		}, false)
+		if err != nil {
+			return
+		}
+	}
+}
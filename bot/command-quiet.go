@@ -0,0 +1,115 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ItalyPaleAle/rss-bot/feeds"
+	"github.com/ItalyPaleAle/rss-bot/models"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Usage message for the /quiet command
+const quietUsage = `Invalid arguments. Usage:
+/quiet - Show the quiet hours of this chat
+/quiet <HH:MM-HH:MM> [timezone] - Set the quiet hours, for example "/quiet 23:00-07:00 Europe/Rome"
+/quiet hold - Hold posts during quiet hours and send them when the quiet hours end
+/quiet silent - Send posts during quiet hours without a notification
+/quiet off - Disable the quiet hours`
+
+// Handles /quiet commands
+func (b *RSSBot) handleQuiet(m *tb.Message) {
+	// Get args
+	args := GetArgs(m.Payload)
+	if len(args) > 2 {
+		b.respondToCommand(m, quietUsage)
+		return
+	}
+
+	// If there's no argument, show the current settings
+	if len(args) == 0 {
+		chat, err := b.feeds.GetChat(m.Chat.ID)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		if chat.QuietHours == "" {
+			b.respondToCommand(m, "This chat doesn't have quiet hours. Set them with \"/quiet <HH:MM-HH:MM> [timezone]\", for example \"/quiet 23:00-07:00 Europe/Rome\".")
+			return
+		}
+		b.respondToCommand(m, fmt.Sprintf("The quiet hours of this chat are %s (%s): %s", chat.QuietHours, chat.Timezone, formatQuietMode(chat.QuietMode)))
+		return
+	}
+
+	// Change the mode or disable the quiet hours
+	switch strings.ToLower(args[0]) {
+	case "off":
+		if len(args) > 1 {
+			b.respondToCommand(m, quietUsage)
+			return
+		}
+		err := b.feeds.SetChatQuietHours(m.Chat.ID, nil)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		b.respondToCommand(m, "Done, this chat doesn't have quiet hours anymore")
+		return
+	case models.QuietModeHold, models.QuietModeSilent:
+		if len(args) > 1 {
+			b.respondToCommand(m, quietUsage)
+			return
+		}
+		mode := strings.ToLower(args[0])
+		err := b.feeds.SetChatQuietMode(m.Chat.ID, mode)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		b.respondToCommand(m, "Done, during quiet hours "+formatQuietMode(mode))
+		return
+	}
+
+	// Parse the quiet hours
+	q, err := feeds.ParseQuietHours(args[0])
+	if err != nil {
+		b.respondToCommand(m, quietUsage)
+		return
+	}
+
+	// Set the time zone, if any
+	if len(args) == 2 {
+		_, err = time.LoadLocation(args[1])
+		if err != nil || args[1] == "" || strings.ToLower(args[1]) == "local" {
+			b.respondToCommand(m, "Invalid time zone: use a name from the tz database, for example \"Europe/Rome\" or \"America/New_York\"")
+			return
+		}
+		err = b.feeds.SetChatTimezone(m.Chat.ID, args[1])
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+	}
+
+	// Set the quiet hours
+	err = b.feeds.SetChatQuietHours(m.Chat.ID, &q)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+	chat, err := b.feeds.GetChat(m.Chat.ID)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+	b.respondToCommand(m, fmt.Sprintf("Done, the quiet hours of this chat are now %s (%s): %s", chat.QuietHours, chat.Timezone, formatQuietMode(chat.QuietMode)))
+}
+
+// Returns a description of what happens to posts during quiet hours
+func formatQuietMode(mode string) string {
+	if mode == models.QuietModeSilent {
+		return "posts are sent without a notification. Use \"/quiet hold\" to send them when the quiet hours end instead."
+	}
+	return "posts are held and sent together when the quiet hours end. Use \"/quiet silent\" to send them without a notification instead."
+}
@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ItalyPaleAle/rss-bot/feeds"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Handles /summary commands
+func (b *RSSBot) handleSummary(m *tb.Message) {
+	// Get args
+	args := GetArgs(m.Payload)
+	if len(args) > 1 {
+		b.respondToCommand(m, fmt.Sprintf("Invalid arguments: need \"/summary [on|off|<length>]\", where length is the maximum number of characters of the summary (up to %d)", feeds.MaxSummaryLength))
+		return
+	}
+
+	// If there's no argument, show the current setting
+	if len(args) == 0 {
+		chat, err := b.feeds.GetChat(m.Chat.ID)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		if chat.SummaryLength == 0 {
+			b.respondToCommand(m, "Messages in this chat don't include the summary of posts. Enable it with \"/summary on\", or choose its maximum length with \"/summary <length>\".")
+		} else {
+			b.respondToCommand(m, fmt.Sprintf("Messages in this chat include the summary of posts, up to %d characters", chat.SummaryLength))
+		}
+		return
+	}
+
+	// Parse the length
+	var length int
+	switch strings.ToLower(args[0]) {
+	case "on":
+		length = feeds.DefaultSummaryLength
+	case "off":
+		length = 0
+	default:
+		var err error
+		length, err = strconv.Atoi(args[0])
+		if err != nil || length < 0 || length > feeds.MaxSummaryLength {
+			b.respondToCommand(m, fmt.Sprintf("Invalid length: it must be between 0 and %d", feeds.MaxSummaryLength))
+			return
+		}
+	}
+
+	// Set the length
+	err := b.feeds.SetChatSummaryLength(m.Chat.ID, length)
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+	if length == 0 {
+		b.respondToCommand(m, "Done, messages in this chat won't include the summary of posts")
+	} else {
+		b.respondToCommand(m, fmt.Sprintf("Done, messages in this chat will include the summary of posts, up to %d characters", length))
+	}
+}
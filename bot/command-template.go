@@ -0,0 +1,151 @@
+package bot
+
+import (
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+
+	"github.com/ItalyPaleAle/rss-bot/feeds"
+	"github.com/ItalyPaleAle/rss-bot/models"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Usage message for the /template command
+const templateUsage = `Usage:
+/template - Show the template for messages in this chat
+/template <preset> - Use one of the built-in templates: %s
+/template <template> - Use a custom template (it can span multiple lines)
+/template reset - Use the default template
+/template <id> [<preset>|<template>|reset] - Show or set the template for a single subscription; "reset" uses the chat's template
+
+Templates use the Go template syntax, and they can contain the HTML tags supported by Telegram, such as <b>, <i>, <a>, <code>, <pre> and <blockquote>. Values are escaped automatically. Available fields: {{.FeedTitle}}, {{.FeedURL}}, {{.Title}}, {{.Link}}, {{.Date}}, {{.Author}}, {{.Categories}}, {{.Summary}} and {{.Image}}.
+Dates are in the chat's time zone and can be formatted with {{.Date.Format "02 Jan 2006 15:04"}}; categories can be joined with {{join .Categories ", "}}. The summary is empty unless it's enabled with /summary.`
+
+// Handles /template commands
+func (b *RSSBot) handleTemplate(m *tb.Message) {
+	// Get the payload, preserving newlines, which are stripped from m.Payload
+	_, payload := CutWord(m.Text)
+	arg, rest := CutWord(payload)
+
+	// If the first argument is a number, this is for a subscription
+	var feed *models.Feed
+	if id, err := strconv.Atoi(arg); err == nil {
+		subs, err := b.feeds.ListSubscriptions(m.Chat.ID)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		if id < 1 || id > len(subs) {
+			b.respondToCommand(m, "Subscription not found")
+			return
+		}
+		feed = &subs[id-1]
+		payload = rest
+		arg, rest = CutWord(payload)
+	}
+
+	// Current template
+	var current string
+	if feed != nil {
+		tpl, err := b.feeds.GetSubscriptionTemplate(feed.ID, m.Chat.ID)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		current = tpl
+	} else {
+		chat, err := b.feeds.GetChat(m.Chat.ID)
+		if err != nil {
+			b.respondToCommand(m, "An internal error occurred")
+			return
+		}
+		current = chat.Template
+	}
+
+	// If there's no template, show the current one
+	if payload == "" {
+		var out string
+		switch {
+		case current == "" && feed != nil:
+			out = fmt.Sprintf("Messages for the feed %s use the template of this chat.", feed.Url)
+		case current == "":
+			out = fmt.Sprintf("Messages in this chat use the \"%s\" template.", defaultTemplatePreset)
+		case feed != nil:
+			out = fmt.Sprintf("Messages for the feed %s use this template:\n\n%s", feed.Url, templateDescription(current))
+		default:
+			out = fmt.Sprintf("Messages in this chat use this template:\n\n%s", templateDescription(current))
+		}
+		b.respondToCommand(m, out+"\n\n"+fmt.Sprintf(templateUsage, strings.Join(templatePresetNames(), ", ")), &tb.SendOptions{
+			DisableWebPagePreview: true,
+		})
+		return
+	}
+
+	// Get the new template
+	var tpl string
+	if strings.ToLower(arg) == "reset" && rest == "" {
+		tpl = ""
+	} else if preset, ok := templatePresets[strings.ToLower(arg)]; ok && rest == "" {
+		tpl = preset
+		// The default preset is the same as not having a template
+		if strings.ToLower(arg) == defaultTemplatePreset && feed == nil {
+			tpl = ""
+		}
+	} else {
+		tpl = payload
+		err := validateMessageTemplate(tpl)
+		if err != nil {
+			b.respondToCommand(m, fmt.Sprintf("Invalid template: %s", err), &tb.SendOptions{
+				DisableWebPagePreview: true,
+			})
+			return
+		}
+	}
+
+	// Store the template
+	var err error
+	if feed != nil {
+		err = b.feeds.SetSubscriptionTemplate(feed.ID, m.Chat.ID, tpl)
+	} else {
+		err = b.feeds.SetChatTemplate(m.Chat.ID, tpl)
+	}
+	if err != nil {
+		b.respondToCommand(m, "An internal error occurred")
+		return
+	}
+
+	// Show an example of the result, with a summary of the default length
+	example := ""
+	if tpl != "" {
+		t, err := parseMessageTemplate(tpl)
+		if err == nil {
+			data := sampleTemplateData
+			data.Summary = template.HTML(feeds.TruncateSummary(string(data.Summary), feeds.DefaultSummaryLength))
+			example, _ = executeMessageTemplate(t, &data)
+		}
+	}
+	switch {
+	case tpl == "" && feed != nil:
+		b.respondToCommand(m, fmt.Sprintf("Done, messages for the feed %s now use the template of this chat", feed.Url), &tb.SendOptions{
+			DisableWebPagePreview: true,
+		})
+	case tpl == "":
+		b.respondToCommand(m, fmt.Sprintf("Done, messages in this chat now use the \"%s\" template", defaultTemplatePreset))
+	default:
+		b.respondToCommand(m, "Done, this is how messages will look like:\n\n"+example, &tb.SendOptions{
+			ParseMode:             tb.ModeHTML,
+			DisableWebPagePreview: true,
+		})
+	}
+}
+
+// Returns the name of the template if it's one of the presets, or the template itself
+func templateDescription(tpl string) string {
+	for name, preset := range templatePresets {
+		if tpl == preset {
+			return fmt.Sprintf("\"%s\" (built-in)", name)
+		}
+	}
+	return tpl
+}
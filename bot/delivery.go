@@ -0,0 +1,185 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+// Interval for checking the outbox for messages to retry
+const outboxPollInterval = 30 * time.Second
+
+// Number of messages to read from the outbox at once
+const outboxBatchSize = 50
+
+// Quiet hours of a chat, as used while delivering messages
+type quietState struct {
+	// End of the current quiet hours, or zero if the chat isn't in its quiet hours
+	Until time.Time
+	// One of the models.QuietMode* constants
+	Mode string
+}
+
+// Delivers messages from the outbox, when notified of new ones and periodically to retry failed ones
+func (b *RSSBot) deliveryWorker(outboxCh <-chan struct{}) {
+	timer := time.NewTimer(outboxPollInterval)
+	defer timer.Stop()
+
+	for {
+		// Deliver messages, then wait until we're notified of new ones or until the next one is ready
+		wait := b.deliverPending()
+		if wait <= 0 || wait > outboxPollInterval {
+			wait = outboxPollInterval
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-outboxCh:
+		case <-timer.C:
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+// Delivers all messages in the outbox that are ready to be sent
+// Messages for chats that are over their rate limit are postponed; this returns the time until the first of them is ready
+func (b *RSSBot) deliverPending() (next time.Duration) {
+	// Remove the state of chats that aren't rate-limited anymore
+	b.limiter.Cleanup()
+
+	// Chats that can't be reached anymore, and chats that were migrated to a new ID
+	gone := make(map[int64]bool)
+	migrated := make(map[int64]int64)
+
+	// End of the quiet hours of each chat, and what to do with messages until then
+	quiet := make(map[int64]quietState)
+
+	for {
+		msgs, err := b.feeds.PendingMessages(outboxBatchSize)
+		if err != nil || len(msgs) == 0 {
+			// Error is already logged
+			return next
+		}
+
+		for i := range msgs {
+			msg := &msgs[i]
+
+			// Messages for chats that can't be reached anymore were removed from the outbox already
+			if gone[msg.ChatId] {
+				continue
+			}
+			// Messages for chats that were migrated were updated in the outbox, but not in this batch
+			if newChatId, ok := migrated[msg.ChatId]; ok {
+				msg.ChatId = newChatId
+			}
+
+			// During the chat's quiet hours, hold the message until they end, or send it without a notification
+			q, ok := quiet[msg.ChatId]
+			if !ok {
+				q.Until, q.Mode, err = b.feeds.QuietUntil(msg.ChatId, time.Now())
+				if err != nil {
+					// Error is already logged
+					return next
+				}
+				quiet[msg.ChatId] = q
+			}
+			silent := false
+			if !q.Until.IsZero() {
+				if q.Mode == models.QuietModeSilent {
+					silent = true
+				} else {
+					err = b.feeds.Postpone(msg, q.Until)
+					if err != nil {
+						return next
+					}
+					continue
+				}
+			}
+
+			// Wait for the rate limiter
+			// If the chat is over its budget, postpone the message
+			wait, err := b.limiter.Reserve(b.ctx, msg.ChatId)
+			if err != nil {
+				// Context was canceled
+				return next
+			}
+			if wait > 0 {
+				if next == 0 || wait < next {
+					next = wait
+				}
+				err = b.feeds.Postpone(msg, time.Now().Add(wait))
+				if err != nil {
+					return next
+				}
+				continue
+			}
+
+			// Claim the message, so it's not sent again even if we can't record the result
+			claimed, err := b.feeds.Claim(msg)
+			if err != nil {
+				// Error is already logged
+				return next
+			}
+			if !claimed {
+				continue
+			}
+
+			// Send the message and record the result
+			// Errors are already logged
+			err = b.sendFeedUpdate(tb.ChatID(msg.ChatId), msg, silent)
+			var limitErr *rateLimitedError
+			if errors.Is(err, context.Canceled) {
+				// We're stopping, and the message wasn't sent: return it to the outbox
+				_ = b.feeds.Release(msg)
+				return next
+			} else if errors.As(err, &limitErr) {
+				// The message needs another slot from the rate limiter, so postpone it
+				if next == 0 || limitErr.Wait < next {
+					next = limitErr.Wait
+				}
+				err = b.feeds.Postpone(msg, time.Now().Add(limitErr.Wait))
+			} else if wait, ok := floodWait(err); ok {
+				// We were rate-limited by Telegram, so try again after the time it requested
+				b.log.Printf("Rate-limited by Telegram while sending to chat %d; retrying in %v\n", msg.ChatId, wait)
+				b.limiter.Block(msg.ChatId, wait)
+				if next == 0 || wait < next {
+					next = wait
+				}
+				err = b.feeds.Postpone(msg, time.Now().Add(wait))
+			} else if kind, newChatId := b.handleChatError(msg.ChatId, err); kind == chatErrorGone {
+				// All subscriptions for the chat, and its messages in the outbox, were removed
+				gone[msg.ChatId] = true
+				continue
+			} else if kind == chatErrorMigrated {
+				// Other messages were moved to the new chat in the outbox; return this one there too, so it will be retried right away with the next batch
+				migrated[msg.ChatId] = newChatId
+				msg.ChatId = newChatId
+				err = b.feeds.Release(msg)
+				if err != nil {
+					return next
+				}
+				continue
+			} else if err != nil {
+				err = b.feeds.MarkFailed(msg, err)
+			} else {
+				err = b.feeds.MarkDelivered(msg)
+			}
+
+			// If we couldn't update the outbox, stop here; the message stays claimed, so it's not sent again
+			if err != nil {
+				return next
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Limits for sending messages, as documented by Telegram
+// See: https://core.telegram.org/bots/faq#my-bot-is-hitting-limits-how-do-i-avoid-this
+const (
+	// Maximum number of messages per second across all chats
+	rateLimitGlobal = 30
+	// Maximum number of messages per minute in a group or channel
+	rateLimitGroup = 20
+	// Maximum number of messages per second in a private chat
+	rateLimitPrivate = 1
+)
+
+// rateLimiter enforces the global and per-chat limits for sending messages
+type rateLimiter struct {
+	lock sync.Mutex
+	// Time when the next message can be sent, for the global limit
+	nextGlobal time.Time
+	// State for each chat
+	chats map[int64]*chatRateLimit
+
+	// Returns the current time; can be replaced in tests
+	now func() time.Time
+}
+
+// State of the rate limiter for a chat
+type chatRateLimit struct {
+	// Times when messages were sent, within the window
+	sent []time.Time
+	// If the chat was rate-limited by Telegram, time until which we can't send messages
+	blockedUntil time.Time
+}
+
+// Returns a new rateLimiter object
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		chats: make(map[int64]*chatRateLimit),
+		now:   time.Now,
+	}
+}
+
+// Returns the maximum number of messages and the window for a chat
+// Chats with negative IDs are groups and channels
+func (r *rateLimiter) chatLimit(chatId int64) (int, time.Duration) {
+	if chatId < 0 {
+		return rateLimitGroup, time.Minute
+	}
+	return rateLimitPrivate, time.Second
+}
+
+// Reserve reserves a slot for sending a message to a chat
+// If the chat is over its budget, nothing is reserved and it returns how long to wait before trying again
+// The global budget is enforced by blocking until the reserved slot starts
+func (r *rateLimiter) Reserve(ctx context.Context, chatId int64) (time.Duration, error) {
+	r.lock.Lock()
+	now := r.now()
+
+	// Check if the chat was blocked by Telegram
+	c, ok := r.chats[chatId]
+	if !ok {
+		c = &chatRateLimit{}
+		r.chats[chatId] = c
+	}
+	if wait := c.blockedUntil.Sub(now); wait > 0 {
+		r.lock.Unlock()
+		return wait, nil
+	}
+
+	// Check the budget for the chat, removing messages sent outside of the window
+	limit, window := r.chatLimit(chatId)
+	n := 0
+	for _, t := range c.sent {
+		if now.Sub(t) < window {
+			c.sent[n] = t
+			n++
+		}
+	}
+	c.sent = c.sent[:n]
+	if len(c.sent) >= limit {
+		wait := c.sent[0].Add(window).Sub(now)
+		r.lock.Unlock()
+		return wait, nil
+	}
+
+	// Reserve the next global slot
+	start := now
+	if r.nextGlobal.After(start) {
+		start = r.nextGlobal
+	}
+	r.nextGlobal = start.Add(time.Second / rateLimitGlobal)
+	c.sent = append(c.sent, start)
+	r.lock.Unlock()
+
+	// Wait for the slot to start
+	if wait := start.Sub(now); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	return 0, nil
+}
+
+// Wait blocks until a slot for sending a message to a chat is available, and reserves it
+func (r *rateLimiter) Wait(ctx context.Context, chatId int64) error {
+	for {
+		wait, err := r.Reserve(ctx, chatId)
+		if err != nil {
+			return err
+		}
+		if wait <= 0 {
+			return nil
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Block prevents sending messages to a chat for the given duration, such as when Telegram responds with a "retry_after" value
+func (r *rateLimiter) Block(chatId int64, d time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	c, ok := r.chats[chatId]
+	if !ok {
+		c = &chatRateLimit{}
+		r.chats[chatId] = c
+	}
+	until := r.now().Add(d)
+	if until.After(c.blockedUntil) {
+		c.blockedUntil = until
+	}
+}
+
+// Cleanup removes the state of chats that are not rate-limited anymore
+func (r *rateLimiter) Cleanup() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	for chatId, c := range r.chats {
+		_, window := r.chatLimit(chatId)
+		if now.After(c.blockedUntil) && (len(c.sent) == 0 || now.Sub(c.sent[len(c.sent)-1]) >= window) {
+			delete(r.chats, chatId)
+		}
+	}
+}
+
+// rateLimitedError is returned when a message can't be sent because the chat is over its budget
+type rateLimitedError struct {
+	// Time to wait before trying again
+	Wait time.Duration
+}
+
+func (e *rateLimitedError) Error() string {
+	return fmt.Sprintf("the chat is over its budget for sending messages; retry in %v", e.Wait)
+}
+
+// Returns the time to wait if the error is a "Too Many Requests" error from Telegram
+func floodWait(err error) (time.Duration, bool) {
+	// If there's no "retry_after" value, telebot returns an APIError rather than a FloodError
+	floodErr := tb.FloodError{}
+	apiErr := &tb.APIError{}
+	switch {
+	case errors.As(err, &floodErr):
+		wait := time.Duration(floodErr.RetryAfter) * time.Second
+		if wait <= 0 {
+			wait = time.Second
+		}
+		return wait, true
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests:
+		return time.Second, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,83 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := newRateLimiter()
+	r.now = func() time.Time { return now }
+	// Skip the global limit, which is tested separately
+	globalSlot := func() {
+		r.nextGlobal = time.Time{}
+	}
+	ctx := context.Background()
+
+	// Private chats: 1 message per second
+	wait, err := r.Reserve(ctx, 1)
+	if err != nil || wait != 0 {
+		t.Fatalf("Expected first message to private chat to be allowed, but got wait=%v err=%v", wait, err)
+	}
+	globalSlot()
+	wait, _ = r.Reserve(ctx, 1)
+	if wait != time.Second {
+		t.Fatalf("Expected second message to private chat to wait 1s, but got %v", wait)
+	}
+
+	// Groups: 20 messages per minute
+	for i := 0; i < rateLimitGroup; i++ {
+		globalSlot()
+		wait, _ = r.Reserve(ctx, -1)
+		if wait != 0 {
+			t.Fatalf("Expected message %d to group to be allowed, but got wait=%v", i, wait)
+		}
+	}
+	globalSlot()
+	wait, _ = r.Reserve(ctx, -1)
+	if wait != time.Minute {
+		t.Fatalf("Expected message to group over the budget to wait 1m, but got %v", wait)
+	}
+
+	// After the window, messages are allowed again
+	now = now.Add(time.Minute)
+	globalSlot()
+	wait, _ = r.Reserve(ctx, -1)
+	if wait != 0 {
+		t.Fatalf("Expected message to group after the window to be allowed, but got wait=%v", wait)
+	}
+
+	// Blocked chats
+	r.Block(2, 5*time.Second)
+	wait, _ = r.Reserve(ctx, 2)
+	if wait != 5*time.Second {
+		t.Fatalf("Expected message to blocked chat to wait 5s, but got %v", wait)
+	}
+
+	// Cleanup removes chats that aren't limited anymore
+	now = now.Add(time.Minute)
+	r.Cleanup()
+	if len(r.chats) != 0 {
+		t.Fatalf("Expected no chats after cleanup, but got %d", len(r.chats))
+	}
+}
+
+func TestRateLimiterGlobal(t *testing.T) {
+	r := newRateLimiter()
+	ctx := context.Background()
+
+	// Sending to different chats is spaced by the global limit
+	start := time.Now()
+	for i := int64(1); i <= 4; i++ {
+		wait, err := r.Reserve(ctx, i)
+		if err != nil || wait != 0 {
+			t.Fatalf("Expected message to chat %d to be allowed, but got wait=%v err=%v", i, wait, err)
+		}
+	}
+	elapsed := time.Since(start)
+	if elapsed < 3*time.Second/rateLimitGlobal {
+		t.Fatalf("Expected messages to be spaced by the global limit, but they took %v", elapsed)
+	}
+}
@@ -0,0 +1,212 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+
+	"github.com/ItalyPaleAle/rss-bot/feeds"
+)
+
+// Name of the preset used when the chat doesn't have a custom template
+const defaultTemplatePreset = "default"
+
+// Built-in templates for messages
+var templatePresets = map[string]string{
+	// The layout used before templates were introduced
+	"default": `{{if .FeedTitle}}🎙 {{.FeedTitle}}:
+{{end}}📬 <b>{{.Title}}</b>
+🕓 {{.Date.Format "Mon, 02 Jan 2006 15:04:05 MST"}}
+{{if .Summary}}
+{{.Summary}}
+
+{{end}}🔗 {{.Link}}
+`,
+	"compact": `{{if .FeedTitle}}🎙 {{.FeedTitle}}: {{end}}<a href="{{.Link}}">{{.Title}}</a>`,
+	"minimal": `{{.Title}}
+{{.Link}}`,
+	"detailed": `{{if .FeedTitle}}🎙 {{.FeedTitle}}:
+{{end}}📬 <b>{{.Title}}</b>
+🕓 {{.Date.Format "Mon, 02 Jan 2006 15:04 MST"}}{{if .Author}} · ✍️ {{.Author}}{{end}}
+{{if .Categories}}🏷 {{join .Categories ", "}}
+{{end}}{{if .Summary}}
+{{.Summary}}
+
+{{end}}🔗 {{.Link}}
+`,
+}
+
+// HTML tags that Telegram accepts in messages
+var allowedTemplateTags = map[string]bool{
+	"b": true, "strong": true,
+	"i": true, "em": true,
+	"u": true, "ins": true,
+	"s": true, "strike": true, "del": true,
+	"span": true, "tg-spoiler": true,
+	"a": true, "code": true, "pre": true, "blockquote": true,
+}
+
+// Functions available in templates
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// Data passed to message templates
+type messageTemplateData struct {
+	FeedTitle  string
+	FeedURL    string
+	Title      string
+	Link       string
+	Date       time.Time
+	Author     string
+	Categories []string
+	// The summary is HTML that was sanitized already
+	Summary template.HTML
+	Image   string
+}
+
+// Data used to validate templates
+var sampleTemplateData = messageTemplateData{
+	FeedTitle:  "Example <Blog> & \"News\"",
+	FeedURL:    "https://example.com/feed.xml",
+	Title:      "A post with <special> & \"characters\"",
+	Link:       "https://example.com/posts/1?a=b&c=d",
+	Date:       time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+	Author:     "Jane Doe",
+	Categories: []string{"News", "Tech"},
+	Summary:    template.HTML("<b>Lorem</b> <i>ipsum</i>" + strings.Repeat(" dolor sit amet", feeds.MaxSummaryLength/15)),
+	Image:      "https://example.com/image.jpg",
+}
+
+// Returns the names of the preset templates, sorted
+func templatePresetNames() []string {
+	names := make([]string, 0, len(templatePresets))
+	for k := range templatePresets {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parses a message template
+func parseMessageTemplate(text string) (*template.Template, error) {
+	return template.New("message").Funcs(templateFuncs).Parse(text)
+}
+
+// Renders a message template
+func executeMessageTemplate(tpl *template.Template, data *messageTemplateData) (string, error) {
+	var b strings.Builder
+	err := tpl.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+	out := b.String()
+	if strings.TrimSpace(out) == "" {
+		return "", errors.New("the message is empty")
+	}
+	if utf8.RuneCountInString(out) > maxMessageLength {
+		return "", fmt.Errorf("the message is longer than %d characters", maxMessageLength)
+	}
+	return out, nil
+}
+
+// Validates a message template, checking that it can be rendered and that Telegram would accept the result
+func validateMessageTemplate(text string) error {
+	tpl, err := parseMessageTemplate(text)
+	if err != nil {
+		return err
+	}
+	out, err := executeMessageTemplate(tpl, &sampleTemplateData)
+	if err != nil {
+		return err
+	}
+	return checkTelegramHTML(out)
+}
+
+// Checks that a message only contains the HTML tags that Telegram accepts, and that they're balanced
+func checkTelegramHTML(str string) error {
+	open := []string{}
+	z := html.NewTokenizer(strings.NewReader(str))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if len(open) > 0 {
+				return fmt.Errorf("the tag <%s> is not closed", open[len(open)-1])
+			}
+			return nil
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if !allowedTemplateTags[tag] {
+				return fmt.Errorf("the tag <%s> is not supported by Telegram", tag)
+			}
+			switch tt {
+			case html.StartTagToken:
+				open = append(open, tag)
+			case html.EndTagToken:
+				if len(open) == 0 || open[len(open)-1] != tag {
+					return fmt.Errorf("the closing tag </%s> doesn't match any open tag", tag)
+				}
+				open = open[:len(open)-1]
+			default:
+				return fmt.Errorf("the tag <%s/> must have a closing tag", tag)
+			}
+		}
+	}
+}
+
+// Returns the length of the text of a HTML message as counted by Telegram, which is in UTF-16 code units
+// Tags are not counted, and entities count as a single character
+func htmlTextLength(str string) int {
+	count := 0
+	z := html.NewTokenizer(strings.NewReader(str))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return count
+		case html.TextToken:
+			for _, r := range string(z.Text()) {
+				if r >= 0x10000 {
+					// Characters outside of the BMP, such as most emojis, are encoded as surrogate pairs
+					count += 2
+				} else {
+					count++
+				}
+			}
+		}
+	}
+}
+
+// Returns the data for rendering the template of a message
+func newMessageTemplateData(msg *feeds.UpdateMessage) *messageTemplateData {
+	// Dates are shown in the chat's time zone
+	loc := time.UTC
+	if msg.Timezone != "" {
+		if l, err := time.LoadLocation(msg.Timezone); err == nil {
+			loc = l
+		}
+	}
+
+	data := &messageTemplateData{
+		Title:      msg.Post.Title,
+		Link:       msg.Post.Link,
+		Date:       msg.Post.Date.In(loc),
+		Author:     msg.Post.Author,
+		Categories: msg.Post.Categories,
+		Summary:    template.HTML(msg.Post.Summary),
+		Image:      msg.Post.Photo,
+	}
+	// Note: the msg.Feed object might be nil
+	if msg.Feed != nil {
+		data.FeedTitle = msg.Feed.Title
+		data.FeedURL = msg.Feed.Url
+	}
+	return data
+}
@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ItalyPaleAle/rss-bot/feeds"
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+func TestTemplatePresets(t *testing.T) {
+	for _, name := range templatePresetNames() {
+		err := validateMessageTemplate(templatePresets[name])
+		if err != nil {
+			t.Fatalf("Expected preset %s to be valid, but got error %s", name, err)
+		}
+	}
+}
+
+func TestValidateMessageTemplate(t *testing.T) {
+	cases := []struct {
+		in  string
+		err bool
+	}{
+		{`{{.Title}}`, false},
+		{`<b>{{.Title}}</b> <a href="{{.Link}}">link</a>`, false},
+		{`<blockquote>{{.Summary}}</blockquote>{{range .Categories}} #{{.}}{{end}}`, false},
+		{`{{.Date.Format "02/01/2006"}}`, false},
+		{``, true},
+		{`   `, true},
+		{`{{.Title`, true},
+		{`{{.Missing}}`, true},
+		{`<div>{{.Title}}</div>`, true},
+		{`<b>{{.Title}}`, true},
+		{`<b>{{.Title}}</i>`, true},
+		{`<br/>{{.Title}}`, true},
+		{`{{range .Categories}}{{$.Summary}}{{$.Summary}}{{$.Summary}}{{$.Summary}}{{end}}`, true},
+	}
+
+	for _, el := range cases {
+		err := validateMessageTemplate(el.in)
+		if (err != nil) != el.err {
+			t.Fatalf("Expected error for %s to be %v, but got %v", el.in, el.err, err)
+		}
+	}
+}
+
+func TestDefaultTemplate(t *testing.T) {
+	b := &RSSBot{}
+	msg := &feeds.UpdateMessage{
+		Feed: &models.Feed{Title: "My <Blog>"},
+		Post: feeds.Post{
+			Title: "Hello & welcome",
+			Link:  "https://example.com/a?b=c&d=e",
+			Date:  time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+		},
+	}
+	expect := "🎙 My &lt;Blog&gt;:\n📬 <b>Hello &amp; welcome</b>\n🕓 Mon, 02 Jan 2006 15:04:05 UTC\n🔗 https://example.com/a?b=c&amp;d=e\n"
+	res := b.formatUpdateMessage(msg)
+	if res != expect {
+		t.Fatalf("Expected result to be %q, but got %q", expect, res)
+	}
+
+	// With a summary, which is HTML already
+	msg.Post.Summary = "<b>Bold</b> &amp; text"
+	expect = "🎙 My &lt;Blog&gt;:\n📬 <b>Hello &amp; welcome</b>\n🕓 Mon, 02 Jan 2006 15:04:05 UTC\n\n<b>Bold</b> &amp; text\n\n🔗 https://example.com/a?b=c&amp;d=e\n"
+	res = b.formatUpdateMessage(msg)
+	if res != expect {
+		t.Fatalf("Expected result to be %q, but got %q", expect, res)
+	}
+	msg.Post.Summary = ""
+
+	// Without a feed, and in the chat's time zone
+	msg.Feed = nil
+	msg.Timezone = "Europe/Rome"
+	res = b.formatUpdateMessage(msg)
+	if strings.Contains(res, "🎙") || !strings.Contains(res, "Mon, 02 Jan 2006 16:04:05 CET") {
+		t.Fatalf("Unexpected result: %q", res)
+	}
+
+	// Custom template
+	msg.Template = `<a href="{{.Link}}">{{.Title}}</a>`
+	expect = `<a href="https://example.com/a?b=c&amp;d=e">Hello &amp; welcome</a>`
+	res = b.formatUpdateMessage(msg)
+	if res != expect {
+		t.Fatalf("Expected result to be %q, but got %q", expect, res)
+	}
+}
+
+func TestHtmlTextLength(t *testing.T) {
+	cases := []struct {
+		in  string
+		out int
+	}{
+		{``, 0},
+		{`Hello`, 5},
+		{`<b>Hello</b> <a href="https://example.com">world</a>`, 11},
+		{`Tom &amp; Jerry`, 11},
+		{`è`, 1},
+		{`📬 <b>Hi</b>`, 5},
+	}
+
+	for _, el := range cases {
+		res := htmlTextLength(el.in)
+		if res != el.out {
+			t.Fatalf("Expected length of %q to be %d, but got %d", el.in, el.out, res)
+		}
+	}
+}
@@ -1,5 +1,13 @@
 package bot
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
 // GetArgs returns a list of arguments from a payload, separated by space
 // Quotes can be used to pass arguments with a space inside, such as `"hello world"`
 func GetArgs(payload string) (args []string) {
@@ -48,3 +56,41 @@ func GetArgs(payload string) (args []string) {
 
 	return
 }
+
+// CutWord returns the first word of a string, and the rest of it
+// Unlike GetArgs, words can be separated by any whitespace including newlines, which are preserved in the rest of the string
+func CutWord(str string) (word string, rest string) {
+	str = strings.TrimLeftFunc(str, unicode.IsSpace)
+	i := strings.IndexFunc(str, unicode.IsSpace)
+	if i < 0 {
+		return str, ""
+	}
+	return str[0:i], strings.TrimLeftFunc(str[i:], unicode.IsSpace)
+}
+
+// ParseInterval parses a duration like "30m", "2h", or "1d"
+// In addition to the units supported by time.ParseDuration, it supports "d" for days
+func ParseInterval(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration '%s'", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// FormatInterval returns a short string for a duration, such as "30m" or "1d"
+func FormatInterval(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d >= time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d >= time.Minute && d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
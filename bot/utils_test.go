@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestGetArgs(t *testing.T) {
@@ -44,3 +45,71 @@ func TestGetArgs(t *testing.T) {
 		}
 	}
 }
+
+func TestCutWord(t *testing.T) {
+	cases := []struct {
+		in   string
+		word string
+		rest string
+	}{
+		{``, ``, ``},
+		{`hi`, `hi`, ``},
+		{`hello world`, `hello`, `world`},
+		{"  hello \n world  again", `hello`, `world  again`},
+		{"/template 1\n<b>{{.Title}}</b>\n{{.Link}}", `/template`, "1\n<b>{{.Title}}</b>\n{{.Link}}"},
+		{"/template\n<b>{{.Title}}</b>", `/template`, `<b>{{.Title}}</b>`},
+	}
+
+	for _, el := range cases {
+		word, rest := CutWord(el.in)
+		if word != el.word || rest != el.rest {
+			t.Fatalf("Expected result for %q to be %q and %q, but got %q and %q", el.in, el.word, el.rest, word, rest)
+		}
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	cases := []struct {
+		in  string
+		out time.Duration
+		err bool
+	}{
+		{`30m`, 30 * time.Minute, false},
+		{`2h`, 2 * time.Hour, false},
+		{`1h30m`, 90 * time.Minute, false},
+		{`1d`, 24 * time.Hour, false},
+		{`7d`, 7 * 24 * time.Hour, false},
+		{`xd`, 0, true},
+		{`hello`, 0, true},
+	}
+
+	for _, el := range cases {
+		res, err := ParseInterval(el.in)
+		if (err != nil) != el.err {
+			t.Fatalf("Expected error for %s to be %v, but got %v", el.in, el.err, err)
+		}
+		if res != el.out {
+			t.Fatalf("Expected result for %s to be %v, but got %v", el.in, el.out, res)
+		}
+	}
+}
+
+func TestFormatInterval(t *testing.T) {
+	cases := []struct {
+		in  time.Duration
+		out string
+	}{
+		{30 * time.Minute, `30m`},
+		{2 * time.Hour, `2h`},
+		{90 * time.Minute, `90m`},
+		{24 * time.Hour, `1d`},
+		{30 * time.Second, `30s`},
+	}
+
+	for _, el := range cases {
+		res := FormatInterval(el.in)
+		if res != el.out {
+			t.Fatalf("Expected result for %v to be %s, but got %s", el.in, el.out, res)
+		}
+	}
+}
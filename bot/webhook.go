@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Header Telegram uses to send the secret token with each webhook request
+const webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Maximum size of the body of webhook requests
+const webhookMaxBodySize = 1 << 20
+
+// Characters allowed by Telegram in the secret token
+var webhookSecretMatch = regexp.MustCompile("^[A-Za-z0-9_-]{1,256}$")
+
+// webhookPoller is a tb.Poller that receives updates via a webhook
+// We're not using tb.Webhook because it doesn't support secret tokens
+type webhookPoller struct {
+	// Address the HTTP server listens on, e.g. ":8080"
+	Listen string
+	// Public URL Telegram sends updates to
+	PublicURL string
+	// Optional secret token that Telegram sends in each request
+	SecretToken string
+	// Optional path to the TLS certificate and key for the HTTP server
+	TLSCert string
+	TLSKey  string
+
+	log      *log.Logger
+	dest     chan<- tb.Update
+	stop     chan struct{}
+	listener net.Listener
+}
+
+// Register starts listening on the address of the HTTP server, then registers the webhook with Telegram
+// This must be called before the bot is started, so errors can stop the bot rather than leaving it running without receiving updates
+func (w *webhookPoller) Register(b *tb.Bot) error {
+	// Listen first, so the server is ready as soon as Telegram sends updates
+	listener, err := net.Listen("tcp", w.Listen)
+	if err != nil {
+		return fmt.Errorf("error while starting the webhook server: %w", err)
+	}
+
+	// Register the webhook
+	params := map[string]string{
+		"url": w.PublicURL,
+	}
+	if w.SecretToken != "" {
+		params["secret_token"] = w.SecretToken
+	}
+	_, err = b.Raw("setWebhook", params)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("error while registering the webhook: %w", err)
+	}
+	w.log.Println("Webhook registered for URL", w.PublicURL)
+
+	w.listener = listener
+	return nil
+}
+
+// Poll starts the HTTP server, which must have been registered already
+// It returns when the stop channel is closed
+func (w *webhookPoller) Poll(b *tb.Bot, dest chan tb.Update, stop chan struct{}) {
+	if w.listener == nil {
+		w.log.Println("Error while starting the webhook server: the webhook was not registered")
+		return
+	}
+
+	w.dest = dest
+	w.stop = stop
+	s := &http.Server{
+		Handler:           w,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Shut the server down when we're asked to stop
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	}()
+
+	var err error
+	if w.TLSCert != "" && w.TLSKey != "" {
+		err = s.ServeTLS(w.listener, w.TLSCert, w.TLSKey)
+	} else {
+		err = s.Serve(w.listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		w.log.Println("Error while starting the webhook server:", err)
+	}
+}
+
+// ServeHTTP handles requests from Telegram, reading the update from the body
+func (w *webhookPoller) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check the secret token if we have one
+	if w.SecretToken != "" {
+		token := r.Header.Get(webhookSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(w.SecretToken)) != 1 {
+			w.log.Println("Ignoring webhook request with invalid secret token")
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Read the update
+	update := tb.Update{}
+	err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, webhookMaxBodySize)).Decode(&update)
+	if err != nil {
+		w.log.Println("Error decoding the webhook update:", err)
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Pass the update to the bot, unless we're stopping (in which case nobody is reading updates anymore)
+	// When we're stopping, respond with an error so Telegram sends the update again later
+	select {
+	case w.dest <- update:
+		rw.WriteHeader(http.StatusOK)
+	case <-w.stop:
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+	}
+}
@@ -37,7 +37,9 @@ func ConnectDB() *sqlx.DB {
 	}
 
 	// Init the singleton
-	db, err = sqlx.Open("sqlite3", "file:"+dbPath+"?cache=shared")
+	// Connections wait for locks rather than failing right away, and transactions take the write lock when they start, so they can't deadlock upgrading it
+	// WAL mode lets readers work while a transaction is open
+	db, err = sqlx.Open("sqlite3", "file:"+dbPath+"?_busy_timeout=10000&_txlock=immediate&_journal_mode=WAL")
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,63 @@
+package feeds
+
+import (
+	"github.com/mmcdole/gofeed"
+
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+// Returns the last n posts of a feed for a chat that is subscribing to it, from old to new, with their metadata
+// If items is nil, the feed is requested; if that fails, the last post stored for the feed is used
+// This sends requests, so it must not be called while a transaction is open
+func (f *Feeds) backfillPosts(feed *models.Feed, items []*gofeed.Item, n int) []Post {
+	if n > MaxBackfill {
+		n = MaxBackfill
+	}
+
+	// Request the feed if needed, using a copy so the stored state (such as the ETag) doesn't matter
+	if items == nil {
+		posts, err := f.RequestFeed(&models.Feed{
+			ID:     feed.ID,
+			Url:    feed.Url,
+			Title:  feed.Title,
+			Source: feed.Source,
+		})
+		if err == nil && posts != nil {
+			items = posts.Items
+		} else if err != nil {
+			f.log.Printf("Error while fetching feed %d for backfill: %s\n", feed.ID, err)
+		}
+	}
+
+	// Get the last posts, from old to new
+	var posts []Post
+	if len(items) > 0 {
+		start := len(items) - n
+		if start < 0 {
+			start = 0
+		}
+		posts = make([]Post, 0, len(items)-start)
+		for _, el := range items[start:] {
+			p := newPost(el)
+
+			// Request the metadata for the post, unless it's the last post which we have already
+			if p.Link == feed.LastPostLink && p.Date.Equal(feed.LastPostDate) {
+				p.Title = feed.LastPostTitle
+				p.Photo = feed.LastPostPhoto
+			} else {
+				f.RequestMetadata(&p, feed)
+			}
+
+			posts = append(posts, p)
+		}
+	} else if feed.LastPostTitle != "" {
+		posts = []Post{{
+			Title: feed.LastPostTitle,
+			Link:  feed.LastPostLink,
+			Date:  feed.LastPostDate,
+			Photo: feed.LastPostPhoto,
+		}}
+	}
+
+	return posts
+}
@@ -0,0 +1,189 @@
+package feeds
+
+import (
+	"database/sql"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+// Default and maximum number of posts sent when subscribing to a feed
+const (
+	DefaultBackfill = 1
+	MaxBackfill     = 20
+)
+
+// GetChat returns the settings of a chat
+// If the chat doesn't have any setting stored, it returns the defaults
+func (f *Feeds) GetChat(chatId int64) (*models.Chat, error) {
+	chat := &models.Chat{}
+	err := db.GetDB().Get(chat, "SELECT * FROM chats WHERE chat_id = ?", chatId)
+	if err == sql.ErrNoRows {
+		return &models.Chat{
+			ID:        chatId,
+			Backfill:  DefaultBackfill,
+			Timezone:  "UTC",
+			QuietMode: models.QuietModeHold,
+			Images:    true,
+		}, nil
+	} else if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return nil, err
+	}
+	return chat, nil
+}
+
+// SetChatBackfill sets the number of posts sent by default when the chat subscribes to a feed
+func (f *Feeds) SetChatBackfill(chatId int64, backfill int) error {
+	return f.setChatSetting(chatId, "chat_backfill", backfill)
+}
+
+// SetChatImages sets whether images of posts are sent to a chat
+func (f *Feeds) SetChatImages(chatId int64, images bool) error {
+	return f.setChatSetting(chatId, "chat_images", images)
+}
+
+// Stores a setting for a chat, creating the chat's row if needed
+// The column name must be a constant and never come from user input
+func (f *Feeds) setChatSetting(chatId int64, column string, value interface{}) error {
+	_, err := db.GetDB().Exec("INSERT INTO chats (chat_id, "+column+") VALUES (?, ?) ON CONFLICT (chat_id) DO UPDATE SET "+column+" = excluded."+column, chatId, value)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteChat removes all subscriptions and settings for a chat, such as when the bot was blocked or the chat was deleted
+// Feeds without any other subscription are removed too
+func (f *Feeds) DeleteChat(chatId int64) error {
+	DB := db.GetDB()
+
+	// Begin a transaction
+	tx, err := DB.Beginx()
+	if err != nil {
+		f.log.Println("Error starting a transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Get the list of feeds the chat is subscribed to
+	feedIds := []int64{}
+	err = tx.Select(&feedIds, "SELECT feed_id FROM subscriptions WHERE chat_id = ?", chatId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	// Delete the subscriptions and the messages that haven't been delivered yet
+	_, err = tx.Exec("DELETE FROM subscriptions WHERE chat_id = ?", chatId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM outbox WHERE chat_id = ? AND status = ?", chatId, models.OutboxStatusPending)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM chats WHERE chat_id = ?", chatId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	// Delete feeds that are not used anymore, and update the interval of the others
+	for _, feedId := range feedIds {
+		err = f.deleteFeedIfUnused(feedId, tx)
+		if err != nil {
+			// Error was already logged
+			return err
+		}
+		err = f.updateFeedInterval(feedId, tx)
+		if err != nil {
+			// Error was already logged
+			return err
+		}
+	}
+
+	// Delete the filters and pending digests for the subscriptions
+	err = f.deleteOrphanRows(tx)
+	if err != nil {
+		// Error was already logged
+		return err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		f.log.Println("Error while committing the transaction:", err)
+		return err
+	}
+
+	f.log.Printf("Removed %d subscriptions for chat %d", len(feedIds), chatId)
+
+	return nil
+}
+
+// MigrateChat moves all subscriptions, settings and pending messages of a chat to a new chat ID, such as when a group is upgraded to a supergroup
+func (f *Feeds) MigrateChat(oldChatId int64, newChatId int64) error {
+	DB := db.GetDB()
+
+	// Begin a transaction
+	tx, err := DB.Beginx()
+	if err != nil {
+		f.log.Println("Error starting a transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// If the new chat is already subscribed to some of the feeds, remove the duplicate subscriptions first
+	_, err = tx.Exec("DELETE FROM subscriptions WHERE chat_id = ? AND feed_id IN (SELECT feed_id FROM subscriptions WHERE chat_id = ?)", oldChatId, newChatId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	// Update the subscriptions and the messages that haven't been delivered yet
+	res, err := tx.Exec("UPDATE subscriptions SET chat_id = ? WHERE chat_id = ?", newChatId, oldChatId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	_, err = tx.Exec("UPDATE outbox SET chat_id = ? WHERE chat_id = ? AND status = ?", newChatId, oldChatId, models.OutboxStatusPending)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	// Move the settings, unless the new chat has its own already
+	_, err = tx.Exec("UPDATE OR IGNORE chats SET chat_id = ? WHERE chat_id = ?", newChatId, oldChatId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM chats WHERE chat_id = ?", oldChatId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	// Delete the filters and pending digests for the duplicate subscriptions that were removed
+	err = f.deleteOrphanRows(tx)
+	if err != nil {
+		// Error was already logged
+		return err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		f.log.Println("Error while committing the transaction:", err)
+		return err
+	}
+
+	count, _ := res.RowsAffected()
+	f.log.Printf("Migrated %d subscriptions from chat %d to chat %d", count, oldChatId, newChatId)
+
+	return nil
+}
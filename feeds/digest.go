@@ -0,0 +1,403 @@
+package feeds
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+// Default time for daily and weekly digests, in the chat's time zone
+const (
+	defaultDigestHour    = 8
+	defaultDigestMinute  = 0
+	defaultDigestWeekday = time.Monday
+)
+
+// Maximum length of each message in a digest; longer digests are split in multiple pages
+const digestMaxMessageLength = 4096
+
+// Error returned when the delivery mode is invalid
+var ErrInvalidDeliveryMode = errors.New("invalid delivery mode: use \"instant\", \"hourly\", \"daily [HH:MM]\" or \"weekly [day] [HH:MM]\"")
+
+// DeliveryMode is how posts are delivered for a subscription: instantly, or collected in a digest
+type DeliveryMode struct {
+	// One of the models.Delivery* constants
+	Kind string
+	// Day of the week, for weekly digests
+	Weekday time.Weekday
+	// Time of the day, for daily and weekly digests
+	Hour   int
+	Minute int
+}
+
+// ParseDeliveryMode parses a delivery mode such as "instant", "hourly", "daily 18:30" or "weekly fri 18:30"
+// The time and the day of the week are optional
+func ParseDeliveryMode(str string) (DeliveryMode, error) {
+	parts := strings.Fields(strings.ToLower(str))
+	if len(parts) == 0 {
+		return DeliveryMode{}, ErrInvalidDeliveryMode
+	}
+
+	mode := DeliveryMode{
+		Kind:    parts[0],
+		Weekday: defaultDigestWeekday,
+		Hour:    defaultDigestHour,
+		Minute:  defaultDigestMinute,
+	}
+	args := parts[1:]
+	switch mode.Kind {
+	case models.DeliveryInstant, models.DeliveryHourly:
+		if len(args) > 0 {
+			return DeliveryMode{}, ErrInvalidDeliveryMode
+		}
+		return DeliveryMode{Kind: mode.Kind}, nil
+	case models.DeliveryWeekly:
+		if len(args) > 0 {
+			if wd, ok := parseWeekday(args[0]); ok {
+				mode.Weekday = wd
+				args = args[1:]
+			}
+		}
+	case models.DeliveryDaily:
+		mode.Weekday = 0
+	default:
+		return DeliveryMode{}, ErrInvalidDeliveryMode
+	}
+
+	// Time of the day
+	if len(args) > 1 {
+		return DeliveryMode{}, ErrInvalidDeliveryMode
+	}
+	if len(args) == 1 {
+		t, err := time.Parse("15:04", args[0])
+		if err != nil {
+			return DeliveryMode{}, ErrInvalidDeliveryMode
+		}
+		mode.Hour = t.Hour()
+		mode.Minute = t.Minute()
+	}
+
+	return mode, nil
+}
+
+// Returns the day of the week from its name, which can be abbreviated to 3 letters
+func parseWeekday(str string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if str == name || str == name[0:3] {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// String returns the delivery mode in the format used in the database, which can be parsed with ParseDeliveryMode
+func (m DeliveryMode) String() string {
+	switch m.Kind {
+	case models.DeliveryDaily:
+		return fmt.Sprintf("%s %02d:%02d", m.Kind, m.Hour, m.Minute)
+	case models.DeliveryWeekly:
+		return fmt.Sprintf("%s %s %02d:%02d", m.Kind, strings.ToLower(m.Weekday.String()[0:3]), m.Hour, m.Minute)
+	case "":
+		return models.DeliveryInstant
+	default:
+		return m.Kind
+	}
+}
+
+// IsDigest returns true if posts are collected in a digest rather than sent instantly
+func (m DeliveryMode) IsDigest() bool {
+	return m.Kind != models.DeliveryInstant && m.Kind != ""
+}
+
+// Next returns the time of the next digest after now, in the given time zone
+// For instant delivery, it returns the zero time
+func (m DeliveryMode) Next(now time.Time, loc *time.Location) time.Time {
+	t := now.In(loc)
+	var next time.Time
+	switch m.Kind {
+	case models.DeliveryHourly:
+		next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc).Add(time.Hour)
+	case models.DeliveryDaily:
+		next = time.Date(t.Year(), t.Month(), t.Day(), m.Hour, m.Minute, 0, 0, loc)
+		if !next.After(t) {
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, m.Hour, m.Minute, 0, 0, loc)
+		}
+	case models.DeliveryWeekly:
+		days := (int(m.Weekday) - int(t.Weekday()) + 7) % 7
+		next = time.Date(t.Year(), t.Month(), t.Day()+days, m.Hour, m.Minute, 0, 0, loc)
+		if !next.After(t) {
+			next = time.Date(t.Year(), t.Month(), t.Day()+days+7, m.Hour, m.Minute, 0, 0, loc)
+		}
+	default:
+		return time.Time{}
+	}
+	return next.UTC()
+}
+
+// SetSubscriptionDelivery sets how posts are delivered for a subscription
+func (f *Feeds) SetSubscriptionDelivery(feedId int64, chatId int64, mode DeliveryMode) error {
+	loc, err := f.chatLocation(chatId)
+	if err != nil {
+		// Error was already logged
+		return err
+	}
+
+	subscriptionId, err := f.getSubscriptionID(feedId, chatId, db.GetDB())
+	if err != nil {
+		return err
+	}
+
+	// If switching to instant delivery, posts collected so far are sent with the next check
+	_, err = db.GetDB().Exec("UPDATE subscriptions SET subscription_delivery = ?, subscription_next_digest_at = ? WHERE subscription_id = ?", mode.String(), mode.Next(time.Now(), loc), subscriptionId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	return nil
+}
+
+// GetSubscriptionDelivery returns how posts are delivered for a subscription, and the time of the next digest
+func (f *Feeds) GetSubscriptionDelivery(feedId int64, chatId int64) (mode DeliveryMode, next time.Time, err error) {
+	sub := &models.Subscription{}
+	err = db.GetDB().Get(sub, "SELECT * FROM subscriptions WHERE feed_id = ? AND chat_id = ?", feedId, chatId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return DeliveryMode{}, time.Time{}, err
+	}
+
+	mode, err = ParseDeliveryMode(sub.Delivery)
+	if err != nil {
+		f.log.Printf("Invalid delivery mode '%s' for subscription %d\n", sub.Delivery, sub.ID)
+		return DeliveryMode{Kind: models.DeliveryInstant}, time.Time{}, nil
+	}
+	return mode, sub.NextDigestAt, nil
+}
+
+// SetChatTimezone sets the time zone of a chat, and re-schedules its digests
+func (f *Feeds) SetChatTimezone(chatId int64, tz string) error {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return err
+	}
+
+	err = f.setChatSetting(chatId, "chat_timezone", loc.String())
+	if err != nil {
+		// Error was already logged
+		return err
+	}
+
+	// Re-schedule the digests
+	subs := []models.Subscription{}
+	err = db.GetDB().Select(&subs, "SELECT * FROM subscriptions WHERE chat_id = ? AND subscription_delivery != ?", chatId, models.DeliveryInstant)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	now := time.Now()
+	for _, sub := range subs {
+		mode, err := ParseDeliveryMode(sub.Delivery)
+		if err != nil {
+			continue
+		}
+		_, err = db.GetDB().Exec("UPDATE subscriptions SET subscription_next_digest_at = ? WHERE subscription_id = ?", mode.Next(now, loc), sub.ID)
+		if err != nil {
+			f.log.Println("Error querying the database:", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the time zone of a chat
+func (f *Feeds) chatLocation(chatId int64) (*time.Location, error) {
+	chat, err := f.GetChat(chatId)
+	if err != nil {
+		// Error was already logged
+		return nil, err
+	}
+	loc, err := time.LoadLocation(chat.Timezone)
+	if err != nil {
+		f.log.Printf("Invalid time zone '%s' for chat %d\n", chat.Timezone, chatId)
+		return time.UTC, nil
+	}
+	return loc, nil
+}
+
+// Adds a post to the digest of a subscription
+func (f *Feeds) queueDigestItem(subscriptionId int64, post *Post, tx *sqlx.Tx) error {
+	_, err := tx.Exec("INSERT INTO digest_items (subscription_id, post_title, post_link, post_date, created_at) VALUES (?, ?, ?, ?, ?)", subscriptionId, post.Title, post.Link, post.Date, time.Now().UTC())
+	if err != nil {
+		f.log.Println("Error inserting in the database:", err)
+		return err
+	}
+	return nil
+}
+
+// SendDueDigests queues the digests that are due in the outbox
+// Subscriptions that were switched to instant delivery have the posts collected so far sent too
+func (f *Feeds) SendDueDigests() error {
+	now := time.Now().UTC()
+	subs := []models.Subscription{}
+	err := db.GetDB().Select(&subs, "SELECT * FROM subscriptions WHERE (subscription_delivery != ? AND subscription_next_digest_at <= ?) OR (subscription_delivery = ? AND subscription_id IN (SELECT subscription_id FROM digest_items))", models.DeliveryInstant, now, models.DeliveryInstant)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	queued := false
+	for i := range subs {
+		n, err := f.sendDigest(&subs[i], now)
+		if err != nil {
+			// Error was already logged
+			return err
+		}
+		if n > 0 {
+			queued = true
+		}
+	}
+
+	if queued {
+		f.notifyOutbox()
+	}
+
+	return nil
+}
+
+// Queues the digest for a subscription, and schedules the next one
+// It returns the number of posts in the digest
+func (f *Feeds) sendDigest(sub *models.Subscription, now time.Time) (int, error) {
+	loc, err := f.chatLocation(sub.ChatID)
+	if err != nil {
+		// Error was already logged
+		return 0, err
+	}
+
+	// Begin a transaction
+	tx, err := db.GetDB().Beginx()
+	if err != nil {
+		f.log.Println("Error starting a transaction:", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Get the posts
+	items := []models.DigestItem{}
+	err = tx.Select(&items, "SELECT * FROM digest_items WHERE subscription_id = ? ORDER BY post_date ASC, digest_item_id ASC", sub.ID)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return 0, err
+	}
+
+	if len(items) > 0 {
+		feed := &models.Feed{}
+		err = tx.Get(feed, "SELECT * FROM feeds WHERE feed_id = ?", sub.FeedID)
+		if err != nil {
+			f.log.Println("Error querying the database:", err)
+			return 0, err
+		}
+
+		for _, page := range formatDigest(feed.Title, items, digestMaxMessageLength) {
+			err = f.queueText(sub.ChatID, sub.FeedID, page, tx)
+			if err != nil {
+				// Error was already logged
+				return 0, err
+			}
+		}
+
+		_, err = tx.Exec("DELETE FROM digest_items WHERE subscription_id = ?", sub.ID)
+		if err != nil {
+			f.log.Println("Error querying the database:", err)
+			return 0, err
+		}
+	}
+
+	// Schedule the next digest
+	mode, err := ParseDeliveryMode(sub.Delivery)
+	if err == nil && mode.IsDigest() {
+		_, err = tx.Exec("UPDATE subscriptions SET subscription_next_digest_at = ? WHERE subscription_id = ?", mode.Next(now, loc), sub.ID)
+		if err != nil {
+			f.log.Println("Error querying the database:", err)
+			return 0, err
+		}
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		f.log.Println("Error while committing the transaction:", err)
+		return 0, err
+	}
+
+	if len(items) > 0 {
+		f.log.Printf("Queued digest with %d posts of feed %d for chat %d\n", len(items), sub.FeedID, sub.ChatID)
+	}
+
+	return len(items), nil
+}
+
+// Formats a digest as a list of titles and links, split in pages no longer than maxLength
+func formatDigest(feedTitle string, items []models.DigestItem, maxLength int) []string {
+	// Format each entry
+	entries := make([]string, len(items))
+	for i, item := range items {
+		entry := "• " + item.PostTitle
+		if item.PostLink != "" {
+			entry += "\n" + item.PostLink
+		}
+		entries[i] = truncateString(entry, maxLength-200) + "\n\n"
+	}
+
+	// Split in pages, leaving room for the header
+	pages := [][]string{}
+	current := []string{}
+	length := 0
+	for _, entry := range entries {
+		if length+len(entry) > maxLength-200 && len(current) > 0 {
+			pages = append(pages, current)
+			current = []string{}
+			length = 0
+		}
+		current = append(current, entry)
+		length += len(entry)
+	}
+	pages = append(pages, current)
+
+	// Add the header to each page
+	header := "📰 Digest of " + feedTitle + ": "
+	if len(items) == 1 {
+		header += "1 new post"
+	} else {
+		header += strconv.Itoa(len(items)) + " new posts"
+	}
+	header = truncateString(header, 150)
+	res := make([]string, len(pages))
+	for i, page := range pages {
+		res[i] = header
+		if len(pages) > 1 {
+			res[i] += fmt.Sprintf(" (%d/%d)", i+1, len(pages))
+		}
+		res[i] += "\n\n" + strings.TrimSpace(strings.Join(page, ""))
+	}
+	return res
+}
+
+// Truncates a string to at most maxLength bytes (plus the ellipsis), without breaking UTF-8 sequences
+func truncateString(str string, maxLength int) string {
+	if len(str) <= maxLength {
+		return str
+	}
+	for maxLength > 0 && !utf8.RuneStart(str[maxLength]) {
+		maxLength--
+	}
+	return str[0:maxLength] + "…"
+}
@@ -0,0 +1,151 @@
+package feeds
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+func TestParseDeliveryMode(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+		err bool
+	}{
+		{"instant", "instant", false},
+		{"hourly", "hourly", false},
+		{"daily", "daily 08:00", false},
+		{"Daily 18:30", "daily 18:30", false},
+		{"weekly", "weekly mon 08:00", false},
+		{"weekly fri", "weekly fri 08:00", false},
+		{"weekly Sunday 7:05", "weekly sun 07:05", false},
+		{"weekly 21:00", "weekly mon 21:00", false},
+		{"", "", true},
+		{"monthly", "", true},
+		{"hourly 10:00", "", true},
+		{"daily 25:00", "", true},
+		{"daily 10:00 11:00", "", true},
+		{"weekly foo 10:00", "", true},
+	}
+
+	for _, el := range cases {
+		mode, err := ParseDeliveryMode(el.in)
+		if el.err {
+			if err == nil {
+				t.Fatalf("Expected an error for %s, but got %s", el.in, mode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Expected no error for %s, but got %s", el.in, err)
+		}
+		if mode.String() != el.out {
+			t.Fatalf("Expected result for %s to be %s, but got %s", el.in, el.out, mode)
+		}
+		// Must be parseable again
+		again, err := ParseDeliveryMode(mode.String())
+		if err != nil || again != mode {
+			t.Fatalf("Expected %s to be parsed again as %s, but got %s (error: %v)", mode, mode, again, err)
+		}
+	}
+}
+
+func TestDeliveryModeNext(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wednesday, 15 March 2023, 10:20 in Rome (09:20 UTC)
+	now := time.Date(2023, 3, 15, 10, 20, 0, 0, rome)
+
+	cases := []struct {
+		mode string
+		loc  *time.Location
+		out  time.Time
+	}{
+		{"instant", rome, time.Time{}},
+		{"hourly", rome, time.Date(2023, 3, 15, 11, 0, 0, 0, rome)},
+		{"daily 18:00", rome, time.Date(2023, 3, 15, 18, 0, 0, 0, rome)},
+		{"daily 10:20", rome, time.Date(2023, 3, 16, 10, 20, 0, 0, rome)},
+		{"daily 08:00", rome, time.Date(2023, 3, 16, 8, 0, 0, 0, rome)},
+		{"daily 08:00", time.UTC, time.Date(2023, 3, 16, 8, 0, 0, 0, time.UTC)},
+		{"weekly fri 09:00", rome, time.Date(2023, 3, 17, 9, 0, 0, 0, rome)},
+		{"weekly wed 09:00", rome, time.Date(2023, 3, 22, 9, 0, 0, 0, rome)},
+		{"weekly wed 11:00", rome, time.Date(2023, 3, 15, 11, 0, 0, 0, rome)},
+		{"weekly sun 08:00", rome, time.Date(2023, 3, 19, 8, 0, 0, 0, rome)},
+	}
+
+	for _, el := range cases {
+		mode, err := ParseDeliveryMode(el.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := mode.Next(now, el.loc)
+		if !res.Equal(el.out) {
+			t.Fatalf("Expected next time for %s to be %s, but got %s", el.mode, el.out, res)
+		}
+	}
+
+	// Across the change to daylight saving time in Rome (26 March 2023 at 02:00, when clocks move from UTC+1 to UTC+2)
+	// The wall-clock time must be kept, so the delay is one hour shorter
+	dstCases := []struct {
+		mode  string
+		now   time.Time
+		delay time.Duration
+	}{
+		{"daily 08:00", time.Date(2023, 3, 25, 8, 0, 0, 0, rome), 23 * time.Hour},
+		{"weekly sun 08:00", time.Date(2023, 3, 19, 8, 0, 0, 0, rome), 7*24*time.Hour - time.Hour},
+	}
+	for _, el := range dstCases {
+		mode, err := ParseDeliveryMode(el.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := mode.Next(el.now, rome)
+		expect := time.Date(2023, 3, 26, 8, 0, 0, 0, rome)
+		if !res.Equal(expect) || res.Sub(el.now) != el.delay {
+			t.Fatalf("Expected next time for %s to be %s, but got %s", el.mode, expect, res)
+		}
+		if h := res.In(rome).Hour(); h != 8 {
+			t.Fatalf("Expected next time for %s to be at 08:00 in Rome, but got %02d:00", el.mode, h)
+		}
+	}
+}
+
+func TestFormatDigest(t *testing.T) {
+	items := []models.DigestItem{
+		{PostTitle: "First", PostLink: "https://example.com/1"},
+		{PostTitle: "Second", PostLink: "https://example.com/2"},
+	}
+	res := formatDigest("Blog", items, 4096)
+	expect := "📰 Digest of Blog: 2 new posts\n\n• First\nhttps://example.com/1\n\n• Second\nhttps://example.com/2"
+	if len(res) != 1 || res[0] != expect {
+		t.Fatalf("Expected result to be %q, but got %q", expect, res)
+	}
+
+	// Pagination
+	items = make([]models.DigestItem, 100)
+	for i := range items {
+		items[i] = models.DigestItem{PostTitle: strings.Repeat("è", 40), PostLink: "https://example.com/" + strings.Repeat("a", 40)}
+	}
+	res = formatDigest("Blog", items, 1000)
+	if len(res) < 2 {
+		t.Fatalf("Expected multiple pages, but got %d", len(res))
+	}
+	count := 0
+	for i, page := range res {
+		if len(page) > 1000 {
+			t.Fatalf("Expected page %d to be at most 1000 bytes, but got %d", i, len(page))
+		}
+		if !strings.HasPrefix(page, "📰 Digest of Blog: 100 new posts (") {
+			t.Fatalf("Unexpected header in page %d: %s", i, page)
+		}
+		count += strings.Count(page, "• ")
+	}
+	if count != 100 {
+		t.Fatalf("Expected 100 posts in total, but got %d", count)
+	}
+}
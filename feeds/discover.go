@@ -0,0 +1,194 @@
+package feeds
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+)
+
+// Content types of the feeds that web pages can link to
+var feedContentTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/feed+json": true,
+}
+
+// Common paths of feeds, tried when a web page doesn't link to any
+var commonFeedPaths = []string{
+	"/feed",
+	"/rss",
+	"/feed.xml",
+	"/rss.xml",
+	"/atom.xml",
+	"/index.xml",
+	"/feed.json",
+}
+
+// Maximum number of bytes read when checking if a response is a feed
+const maxFeedSniffLength = 64 << 10
+
+// FeedCandidate is a feed found on a web page
+type FeedCandidate struct {
+	Url   string
+	Title string
+}
+
+// FeedDiscoveryError is returned when the URL points to a web page rather than to a feed
+// It contains the feeds that were found on the page, if any
+type FeedDiscoveryError struct {
+	Candidates []FeedCandidate
+}
+
+func (e *FeedDiscoveryError) Error() string {
+	if len(e.Candidates) == 0 {
+		return "the URL is a web page and no feed was found on it"
+	}
+	return "the URL is a web page that links to feeds"
+}
+
+// Looks for the feeds linked from a web page that was requested as a feed, re-using the response
+// This is used only when adding or previewing a feed whose URL isn't a feed, because it can send many requests
+// It returns a FeedDiscoveryError, or the original error if it doesn't contain the page
+func (f *Feeds) discoverPageFeeds(err error) error {
+	var fetchErr *FetchError
+	if !errors.As(err, &fetchErr) || fetchErr.pageUrl == nil {
+		return err
+	}
+	return f.discoverFeeds(fetchErr.page, fetchErr.pageUrl)
+}
+
+// Looks for feeds on a web page
+// It returns the feeds the page links to; if there's none, it tries some common paths
+func (f *Feeds) discoverFeeds(body []byte, pageUrl *url.URL) *FeedDiscoveryError {
+	res := &FeedDiscoveryError{
+		Candidates: findFeedLinks(bytes.NewReader(body), pageUrl),
+	}
+	if len(res.Candidates) > 0 {
+		return res
+	}
+
+	// Try the common paths, relative to both the page and the root of the site
+	tried := map[string]bool{}
+	bases := []string{"/"}
+	if p := strings.TrimRight(pageUrl.Path, "/"); p != "" {
+		bases = append([]string{p + "/"}, bases...)
+	}
+	for _, base := range bases {
+		for _, p := range commonFeedPaths {
+			u := *pageUrl
+			u.Path = strings.TrimRight(base, "/") + p
+			u.RawPath = ""
+			u.RawQuery = ""
+			u.Fragment = ""
+			candidate := u.String()
+			if tried[candidate] {
+				continue
+			}
+			tried[candidate] = true
+
+			if f.isFeed(candidate) {
+				res.Candidates = append(res.Candidates, FeedCandidate{Url: candidate})
+				return res
+			}
+		}
+	}
+
+	return res
+}
+
+// Returns true if the URL points to a feed
+func (f *Feeds) isFeed(feedUrl string) bool {
+	req, err := http.NewRequest("GET", feedUrl, nil)
+	if err != nil {
+		return false
+	}
+	req = req.WithContext(f.ctx)
+	req.Header.Set("User-Agent", "RSSBot/1.0")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return false
+	}
+	return gofeed.DetectFeedType(io.LimitReader(resp.Body, maxFeedSniffLength)) != gofeed.FeedTypeUnknown
+}
+
+// Returns the feeds linked from a web page with <link rel="alternate">
+func findFeedLinks(r io.Reader, pageUrl *url.URL) []FeedCandidate {
+	res := []FeedCandidate{}
+	found := map[string]bool{}
+
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// End of the document (or an error we can't recover from)
+			return res
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) == "body" {
+				// Links are in the head only
+				return res
+			}
+			if string(name) != "link" || !hasAttr {
+				continue
+			}
+
+			// Read the attributes
+			var rel, typ, href, title string
+			for {
+				key, val, more := z.TagAttr()
+				switch string(key) {
+				case "rel":
+					rel = strings.ToLower(string(val))
+				case "type":
+					typ = strings.ToLower(strings.TrimSpace(string(val)))
+				case "href":
+					href = strings.TrimSpace(string(val))
+				case "title":
+					title = strings.TrimSpace(string(val))
+				}
+				if !more {
+					break
+				}
+			}
+			if href == "" || !feedContentTypes[typ] || !containsWord(rel, "alternate") {
+				continue
+			}
+
+			// Resolve relative links
+			u, err := pageUrl.Parse(href)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				continue
+			}
+			link := u.String()
+			if found[link] {
+				continue
+			}
+			found[link] = true
+			res = append(res, FeedCandidate{
+				Url:   link,
+				Title: title,
+			})
+		}
+	}
+}
+
+// Returns true if the space-separated list contains the word
+func containsWord(list string, word string) bool {
+	for _, w := range strings.Fields(list) {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package feeds
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindFeedLinks(t *testing.T) {
+	page, _ := url.Parse("https://example.com/blog/")
+	doc := `<!DOCTYPE html>
+<html>
+<head>
+	<title>Blog</title>
+	<link rel="stylesheet" type="text/css" href="/style.css">
+	<link rel="alternate" type="application/rss+xml" title="Posts" href="/feed.xml">
+	<link rel="alternate" type="application/atom+xml" href="https://example.com/blog/atom.xml" />
+	<link rel="Alternate" type="application/feed+json" href="feed.json">
+	<link rel="alternate" type="application/rss+xml" href="/feed.xml">
+	<link rel="alternate" type="application/json" href="/wp-json/wp/v2/pages/1">
+	<link rel="alternate" hreflang="it" href="/it/">
+</head>
+<body>
+	<link rel="alternate" type="application/rss+xml" href="/comments.xml">
+</body>
+</html>`
+
+	res := findFeedLinks(strings.NewReader(doc), page)
+	expect := []FeedCandidate{
+		{Url: "https://example.com/feed.xml", Title: "Posts"},
+		{Url: "https://example.com/blog/atom.xml"},
+		{Url: "https://example.com/blog/feed.json"},
+	}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf("Expected result to be %v, but got %v", expect, res)
+	}
+
+	// No links
+	res = findFeedLinks(strings.NewReader("<html><head></head><body>Hello</body></html>"), page)
+	if len(res) != 0 {
+		t.Fatalf("Expected no results, but got %v", res)
+	}
+}
@@ -0,0 +1,111 @@
+package feeds
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// Kinds of errors that can happen while fetching a feed
+const (
+	FetchErrorInvalidURL = "invalid-url"
+	FetchErrorDNS        = "dns"
+	FetchErrorTimeout    = "timeout"
+	FetchErrorHTTPStatus = "http-status"
+	FetchErrorTLS        = "tls"
+	FetchErrorParse      = "parse"
+	FetchErrorNoItems    = "no-items"
+)
+
+// FetchError is returned when a feed can't be fetched or parsed
+// The original error is wrapped, and it can be retrieved with errors.As
+type FetchError struct {
+	Kind string
+	// HTTP status code, for FetchErrorHTTPStatus
+	StatusCode int
+	// Line where parsing failed, for FetchErrorParse (0 if unknown)
+	Line int
+	Err  error
+
+	// For parse errors of responses that aren't feeds, the response and its URL, used to look for the feeds the page links to
+	page    []byte
+	pageUrl *url.URL
+}
+
+func (e *FetchError) Error() string {
+	switch e.Kind {
+	case FetchErrorNoItems:
+		return "the feed doesn't contain any post with a valid title and date"
+	case FetchErrorParse:
+		if e.Line > 0 {
+			return fmt.Sprintf("error parsing the feed at line %d: %s", e.Line, e.Err)
+		}
+		return fmt.Sprintf("error parsing the feed: %s", e.Err)
+	default:
+		return e.Err.Error()
+	}
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// Returns a FetchError with the kind of the error, or the error itself if it's of an unknown kind
+func classifyFetchError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// Errors that have been classified already
+	var fetchErr *FetchError
+	var discoveryErr *FeedDiscoveryError
+	if errors.As(err, &fetchErr) || errors.As(err, &discoveryErr) {
+		return err
+	}
+
+	// DNS errors go before timeouts, as they can be timeouts too
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return &FetchError{Kind: FetchErrorDNS, Err: err}
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &FetchError{Kind: FetchErrorTimeout, Err: err}
+	}
+
+	// TLS errors
+	var (
+		unknownAuthorityErr x509.UnknownAuthorityError
+		hostnameErr         x509.HostnameError
+		invalidCertErr      x509.CertificateInvalidError
+		recordHeaderErr     tls.RecordHeaderError
+	)
+	if errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidCertErr) || errors.As(err, &recordHeaderErr) {
+		return &FetchError{Kind: FetchErrorTLS, Err: err}
+	}
+
+	// Errors from the server
+	httpErr := gofeed.HTTPError{}
+	if errors.As(err, &httpErr) {
+		return &FetchError{Kind: FetchErrorHTTPStatus, StatusCode: httpErr.StatusCode, Err: err}
+	}
+
+	return err
+}
+
+// Returns a FetchError for an error returned while parsing a feed, with the line where it happened if known
+func newParseError(err error) *FetchError {
+	res := &FetchError{Kind: FetchErrorParse, Err: err}
+	var xmlErr *xml.SyntaxError
+	if errors.As(err, &xmlErr) {
+		res.Line = xmlErr.Line
+	}
+	return res
+}
@@ -0,0 +1,62 @@
+package feeds
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestClassifyFetchError(t *testing.T) {
+	cases := []struct {
+		in     error
+		kind   string
+		status int
+	}{
+		{&url.Error{Op: "Get", URL: "https://example.invalid", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}}}, FetchErrorDNS, 0},
+		{&url.Error{Op: "Get", URL: "https://example.com", Err: context.DeadlineExceeded}, FetchErrorTimeout, 0},
+		{&url.Error{Op: "Get", URL: "https://example.com", Err: x509.UnknownAuthorityError{}}, FetchErrorTLS, 0},
+		{&url.Error{Op: "Get", URL: "https://example.com", Err: x509.HostnameError{Certificate: &x509.Certificate{}, Host: "example.com"}}, FetchErrorTLS, 0},
+		{gofeed.HTTPError{StatusCode: 403, Status: "403 Forbidden"}, FetchErrorHTTPStatus, 403},
+		{fmt.Errorf("wrapped: %w", gofeed.HTTPError{StatusCode: 404, Status: "404 Not Found"}), FetchErrorHTTPStatus, 404},
+		{&FetchError{Kind: FetchErrorNoItems}, FetchErrorNoItems, 0},
+		{newParseError(&xml.SyntaxError{Msg: "unexpected EOF", Line: 12}), FetchErrorParse, 0},
+	}
+
+	for _, el := range cases {
+		res := classifyFetchError(el.in)
+		fetchErr := &FetchError{}
+		if !errors.As(res, &fetchErr) {
+			t.Fatalf("Expected error %v to be a FetchError, but got %T", el.in, res)
+		}
+		if fetchErr.Kind != el.kind || fetchErr.StatusCode != el.status {
+			t.Fatalf("Expected error %v to be of kind %s with status %d, but got %s with status %d", el.in, el.kind, el.status, fetchErr.Kind, fetchErr.StatusCode)
+		}
+	}
+
+	// The original error must be preserved
+	if !isFeedGone(classifyFetchError(gofeed.HTTPError{StatusCode: 410, Status: "410 Gone"})) {
+		t.Fatal("Expected 410 errors to be detected after being classified")
+	}
+
+	// Line of parse errors
+	parseErr := newParseError(fmt.Errorf("wrapped: %w", &xml.SyntaxError{Msg: "unexpected EOF", Line: 12}))
+	if parseErr.Line != 12 {
+		t.Fatalf("Expected parse error at line 12, but got %d", parseErr.Line)
+	}
+
+	// Unknown errors are returned as-is
+	unknown := errors.New("unknown")
+	if classifyFetchError(unknown) != unknown {
+		t.Fatal("Expected unknown errors to be returned as-is")
+	}
+	if classifyFetchError(nil) != nil {
+		t.Fatal("Expected nil errors to be returned as-is")
+	}
+}
@@ -95,7 +95,13 @@ func (f *Feeds) RequestDockerFeed(feed *models.Feed) (posts *gofeed.Feed, err er
 	// Iterate through the results
 	posts.Items = make([]*gofeed.Item, len(body.Results))
 	for i, el := range body.Results {
+		// The GUID includes the date of the last update, so tags that are pushed again are new items
+		guid := fmt.Sprintf("%s:%s", fullName, el.Tag)
+		if el.LastUpdated != nil {
+			guid += "@" + el.LastUpdated.UTC().Format(time.RFC3339)
+		}
 		posts.Items[i] = &gofeed.Item{
+			GUID:            guid,
 			Title:           el.Tag,
 			PublishedParsed: el.LastUpdated,
 			Author:          &gofeed.Person{Name: el.LastUpdaterUsername},
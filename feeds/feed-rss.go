@@ -1,8 +1,12 @@
 package feeds
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/Songmu/go-httpdate"
@@ -11,12 +15,19 @@ import (
 	"github.com/ItalyPaleAle/rss-bot/models"
 )
 
+// Maximum number of bytes read from a feed
+const maxFeedSize = 10 << 20
+
 // RequestRSSFeed requests a RSS feed and parses it with gofeed
 // We're using this rather than gofeed.ParseURL to have more control on the request
 func (f *Feeds) RequestRSSFeed(feed *models.Feed) (posts *gofeed.Feed, err error) {
 	if feed.Url == "" {
 		return nil, errors.New("empty feed URL")
 	}
+	u, err := url.Parse(feed.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, &FetchError{Kind: FetchErrorInvalidURL, Err: errors.New("the URL must start with http:// or https://")}
+	}
 
 	// Create the request
 	req, err := http.NewRequest("GET", feed.Url, nil)
@@ -33,11 +44,16 @@ func (f *Feeds) RequestRSSFeed(feed *models.Feed) (posts *gofeed.Feed, err error
 	}
 
 	// Send the request and read the data
-	resp, err := f.client.Do(req)
+	// If the feed has moved permanently, record the new URL
+	resp, movedTo, err := f.doRequestTrackRedirects(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if movedTo != "" && movedTo != feed.Url {
+		f.log.Printf("Feed %s has moved permanently to %s\n", feed.Url, movedTo)
+		feed.MovedTo = movedTo
+	}
 
 	// Status code
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
@@ -65,15 +81,31 @@ func (f *Feeds) RequestRSSFeed(feed *models.Feed) (posts *gofeed.Feed, err error
 		}
 	}
 
-	// Parse the feed
-	fp := gofeed.NewParser()
-	posts, err = fp.Parse(resp.Body)
+	// Read the feed, up to the maximum size, and parse it
+	// If the URL is a web page, this returns a parse error wrapping gofeed.ErrFeedTypeNotDetected
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
 	if err != nil {
 		return nil, err
 	}
+	if len(body) > maxFeedSize {
+		return nil, newParseError(fmt.Errorf("the feed is bigger than %d bytes", maxFeedSize))
+	}
+	fp := gofeed.NewParser()
+	posts, err = fp.Parse(bytes.NewReader(body))
+	if err != nil {
+		parseErr := newParseError(err)
+		if errors.Is(err, gofeed.ErrFeedTypeNotDetected) {
+			// Keep the page, so we can look for feeds on it without requesting it again
+			parseErr.page = body
+			parseErr.pageUrl = resp.Request.URL
+		}
+		return nil, parseErr
+	}
 
 	// Iterate through the results
 	n := 0
+	feed.SkippedInvalidDate = 0
+	feed.SkippedEmptyTitle = 0
 	for _, el := range posts.Items {
 		// If there's an updated date, use that instead of published
 		if el.Updated != "" && el.UpdatedParsed != nil && !el.UpdatedParsed.IsZero() {
@@ -83,12 +115,14 @@ func (f *Feeds) RequestRSSFeed(feed *models.Feed) (posts *gofeed.Feed, err error
 		// Skip items with an invalid date
 		if el.PublishedParsed == nil || el.PublishedParsed.IsZero() {
 			f.log.Printf("Error in feed %s: skipping entry with invalid date '%s' (error: %s)\n", feed.Url, el.Published, err)
+			feed.SkippedInvalidDate++
 			continue
 		}
 
 		// Skip items with an empty title
 		if el.Title == "" {
 			f.log.Printf("Error in feed %s: skipping entry with empty title\n", feed.Url)
+			feed.SkippedEmptyTitle++
 			continue
 		}
 
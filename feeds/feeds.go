@@ -7,27 +7,74 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
 
 	"github.com/ItalyPaleAle/rss-bot/db"
 	"github.com/ItalyPaleAle/rss-bot/models"
+	"github.com/ItalyPaleAle/rss-bot/utils"
 )
 
 // Post represents a post in the feed
 type Post struct {
-	Title string
-	Link  string
-	Date  time.Time
-	Photo string
+	Title      string
+	Link       string
+	Date       time.Time
+	Photo      string
+	Author     string
+	Categories []string
+	// Summary of the post, as HTML that Telegram accepts
+	Summary string
+}
+
+// Returns a Post object for an item in the feed
+func newPost(el *gofeed.Item) Post {
+	p := Post{
+		Title:      el.Title,
+		Link:       el.Link,
+		Date:       *el.PublishedParsed,
+		Categories: el.Categories,
+		Summary:    postSummary(el.Description, el.Content),
+		Photo:      itemImage(el),
+	}
+
+	// Get the authors' names
+	authors := make([]string, 0, len(el.Authors))
+	for _, a := range el.Authors {
+		if a != nil && a.Name != "" {
+			authors = append(authors, a.Name)
+		}
+	}
+	if len(authors) == 0 && el.Author != nil && el.Author.Name != "" {
+		authors = append(authors, el.Author.Name)
+	}
+	p.Author = strings.Join(authors, ", ")
+
+	return p
 }
 
 // UpdateMessage is the message that needs to be sent to subscribers for new posts
 type UpdateMessage struct {
+	// ID of the message in the outbox, and number of delivery attempts so far
+	ID       int64
+	Attempts int
+
 	Feed   *models.Feed
 	Post   Post
 	ChatId int64
+
+	// If set, this is a text message (such as a notification about the feed's status) rather than a post
+	Text string
+	// If set, only the post's photo is sent, such as for posts whose message was too long to be the photo's caption
+	PhotoOnly bool
+
+	// Template for the message, if the subscription or the chat has a custom one, and time zone of the chat
+	Template string
+	Timezone string
 }
 
 // Timeout for HTTP requests
@@ -42,8 +89,17 @@ type Feeds struct {
 	log       *log.Logger
 	semaphore chan int
 	waiting   chan int
-	updateCh  chan<- UpdateMessage
+	outboxCh  chan<- struct{}
 	client    *http.Client
+
+	// Default interval for fetching feeds
+	defaultInterval time.Duration
+	// Time after which subscribers are notified of failing feeds, and failing feeds are paused
+	failureNotifyAfter time.Duration
+	pauseAfter         time.Duration
+
+	sources       []Source
+	defaultSource Source
 }
 
 // Init the object
@@ -62,13 +118,73 @@ func (f *Feeds) Init(ctx context.Context) (err error) {
 		Timeout: requestTimeout,
 	}
 
+	// Default interval for fetching feeds
+	f.defaultInterval = viper.GetDuration("FeedUpdateInterval") * time.Second
+	if f.defaultInterval < MinFetchInterval {
+		f.defaultInterval = MinFetchInterval
+	}
+
+	// Time after which failing feeds are reported and paused
+	f.failureNotifyAfter = viper.GetDuration("FeedFailureNotifyAfter") * time.Second
+	f.pauseAfter = viper.GetDuration("FeedPauseAfter") * time.Second
+
+	// Register the sources
+	// RSS is the default one, used when no other source matches
+	f.defaultSource = rssSource{}
+	f.RegisterSource(dockerSource{})
+
 	return nil
 }
 
 // AddSubscription subscribes a chat to a feed, adding the feed if required
-func (f *Feeds) AddSubscription(url string, chatId int64) (*Post, error) {
+// The last backfill posts are queued for the chat; if backfill is negative, the chat's default is used
+// It returns the feed and the number of posts that were queued
+func (f *Feeds) AddSubscription(url string, chatId int64, backfill int) (*models.Feed, int, error) {
 	if chatId < 1 {
-		return nil, errors.New("Empty chat ID")
+		return nil, 0, errors.New("Empty chat ID")
+	}
+
+	// Use the chat's default number of posts to send
+	if backfill < 0 {
+		chat, err := f.GetChat(chatId)
+		if err != nil {
+			// Error was already logged
+			return nil, 0, err
+		}
+		backfill = chat.Backfill
+	}
+
+	// Check if the feed exists already, or request it if it's new
+	// Requests are sent before starting the transaction, so it isn't kept open while waiting for them
+	feed, err := f.GetFeedByURL(url, nil)
+	if err != nil {
+		// Error was already logged
+		return nil, 0, err
+	}
+	var items []*gofeed.Item
+	if feed == nil {
+		feed, items, err = f.requestNewFeed(url, true)
+		if err != nil {
+			// Error was already logged
+			return nil, 0, err
+		}
+	} else {
+		// Don't request anything if the chat is subscribed already
+		count := 0
+		err = db.GetDB().Get(&count, "SELECT COUNT(*) FROM subscriptions WHERE feed_id = ? AND chat_id = ?", feed.ID, chatId)
+		if err != nil {
+			f.log.Println("Error querying the database:", err)
+			return nil, 0, err
+		}
+		if count > 0 {
+			return nil, 0, ErrAlreadySubscribed
+		}
+	}
+
+	// Get the last posts to send to the chat, with their metadata
+	var posts []Post
+	if backfill > 0 {
+		posts = f.backfillPosts(feed, items, backfill)
 	}
 
 	DB := db.GetDB()
@@ -77,23 +193,25 @@ func (f *Feeds) AddSubscription(url string, chatId int64) (*Post, error) {
 	tx, err := DB.Beginx()
 	if err != nil {
 		f.log.Println("Error starting a transaction:", err)
-		return nil, err
+		return nil, 0, err
 	}
 	defer tx.Rollback()
 
-	// Check if the feed exists already
-	feed, err := f.GetFeedByURL(url, tx)
-	if err != nil {
-		// Error was already logged
-		return nil, err
-	}
-
-	// If the feed doesn't exist, add it
-	if feed == nil || feed.ID < 1 {
-		feed, err = f.AddFeed(url, tx)
+	// Add the feed if it's new, unless it was added in the meanwhile
+	if feed.ID < 1 {
+		existing, err := f.GetFeedByURL(feed.Url, tx)
 		if err != nil {
 			// Error was already logged
-			return nil, err
+			return nil, 0, err
+		}
+		if existing != nil {
+			feed = existing
+		} else {
+			err = f.insertFeed(feed, items, tx)
+			if err != nil {
+				// Error was already logged
+				return nil, 0, err
+			}
 		}
 	}
 
@@ -102,37 +220,51 @@ func (f *Feeds) AddSubscription(url string, chatId int64) (*Post, error) {
 	err = tx.Get(subscription, "SELECT subscription_id FROM subscriptions WHERE feed_id = ? AND chat_id = ? LIMIT 1", feed.ID, chatId)
 	// There should be an error, and it should be ErrNoRows
 	if err == nil {
-		return nil, ErrAlreadySubscribed
+		return nil, 0, ErrAlreadySubscribed
 	} else if err != sql.ErrNoRows {
 		// Another error, needs to be handled
 		f.log.Println("Error querying the database:", err)
-		return nil, err
+		return nil, 0, err
 	}
 
 	// Add the subscription
 	_, err = tx.Exec("INSERT INTO subscriptions (feed_id, chat_id) VALUES (?, ?)", feed.ID, chatId)
 	if err != nil {
 		f.log.Println("Error querying the database:", err)
-		return nil, err
+		return nil, 0, err
 	}
 
+	// The new subscriber uses the default interval, which could be shorter than the feed's current one
+	err = f.updateFeedInterval(feed.ID, tx)
+	if err != nil {
+		// Error was already logged
+		return nil, 0, err
+	}
+
+	// Queue the last posts for the chat
+	for i := range posts {
+		err = f.queueMessage(chatId, feed.ID, &posts[i], tx)
+		if err != nil {
+			// Error was already logged
+			return nil, 0, err
+		}
+	}
+	queued := len(posts)
+
 	// Commit the transaction
 	err = tx.Commit()
 	if err != nil {
 		f.log.Println("Error while committing the transaction:", err)
-		return nil, err
+		return nil, 0, err
 	}
 
 	f.log.Printf("Added feed %s (ID %d) to chat %d", url, feed.ID, chatId)
 
-	// Get the Post object from the feed
-	post := &Post{
-		Title: feed.LastPostTitle,
-		Link:  feed.LastPostLink,
-		Date:  feed.LastPostDate,
-		Photo: feed.LastPostPhoto,
+	if queued > 0 {
+		f.notifyOutbox()
 	}
-	return post, nil
+
+	return feed, queued, nil
 }
 
 // DeleteSubscription removes a subscription to a feed
@@ -155,28 +287,120 @@ func (f *Feeds) DeleteSubscription(feedId int64, chatId int64) error {
 		return err
 	}
 
+	// Delete messages for the subscription that haven't been delivered yet
+	_, err = tx.Exec("DELETE FROM outbox WHERE feed_id = ? AND chat_id = ? AND status = ?", feedId, chatId, models.OutboxStatusPending)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	// If this was the last subscription to the feed, delete the feed too
+	err = f.deleteFeedIfUnused(feedId, tx)
+	if err != nil {
+		// Error was already logged
+		return err
+	}
+
+	// Delete the filters and pending digest for the subscription
+	err = f.deleteOrphanRows(tx)
+	if err != nil {
+		// Error was already logged
+		return err
+	}
+
+	// Update the interval of the feed, if it still exists, as this subscriber might have had the shortest one
+	err = f.updateFeedInterval(feedId, tx)
+	if err != nil {
+		// Error was already logged
+		return err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		f.log.Println("Error while committing the transaction:", err)
+		return err
+	}
+
+	return nil
+}
+
+// Deletes a feed if there are no more subscriptions for it
+func (f *Feeds) deleteFeedIfUnused(feedId int64, tx *sqlx.Tx) error {
 	// Check if there are other subscriptions for this feed
 	subscription := &models.Subscription{}
-	err = tx.Get(subscription, "SELECT subscription_id FROM subscriptions WHERE feed_id = ?", feedId)
+	err := tx.Get(subscription, "SELECT subscription_id FROM subscriptions WHERE feed_id = ? LIMIT 1", feedId)
+	if err == nil {
+		// Feed is still in use
+		return nil
+	} else if err != sql.ErrNoRows {
+		// Another error, needs to be handled
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	// If there are no more rows, delete the feed
+	_, err = tx.Exec("DELETE FROM feeds WHERE feed_id = ?", feedId)
 	if err != nil {
-		// If there are no more rows, delete the feed
-		if err == sql.ErrNoRows {
-			_, err = tx.Exec("DELETE FROM feeds WHERE feed_id = ?", feedId)
-			if err != nil {
-				f.log.Println("Error querying the database:", err)
-				return err
-			}
-		} else {
-			// Another error, needs to be handled
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM seen_items WHERE feed_id = ?", feedId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	return nil
+}
+
+// Merges a feed into another one, moving its subscriptions, pending messages and seen items, then deletes it
+// Chats that are subscribed to both feeds keep the subscription to the target one
+func (f *Feeds) mergeFeed(fromId int64, toId int64, tx *sqlx.Tx) error {
+	queries := []string{
+		// Chats subscribed to both feeds would get the same posts twice, so remove their pending messages for the old feed
+		"DELETE FROM outbox WHERE feed_id = ? AND status = ? AND chat_id IN (SELECT chat_id FROM subscriptions WHERE feed_id = ?)",
+		// Remove duplicate subscriptions, then move the others
+		"DELETE FROM subscriptions WHERE feed_id = ? AND chat_id IN (SELECT chat_id FROM subscriptions WHERE feed_id = ?)",
+		"UPDATE subscriptions SET feed_id = ? WHERE feed_id = ?",
+		// Move the messages that haven't been delivered yet
+		"UPDATE outbox SET feed_id = ? WHERE feed_id = ? AND status = ?",
+		// Move the seen items
+		"INSERT OR IGNORE INTO seen_items (feed_id, item_key, seen_at) SELECT ?, item_key, seen_at FROM seen_items WHERE feed_id = ?",
+	}
+	args := [][]interface{}{
+		{fromId, models.OutboxStatusPending, toId},
+		{fromId, toId},
+		{toId, fromId},
+		{toId, fromId, models.OutboxStatusPending},
+		{toId, fromId},
+	}
+	for i, q := range queries {
+		_, err := tx.Exec(q, args[i]...)
+		if err != nil {
 			f.log.Println("Error querying the database:", err)
 			return err
 		}
 	}
 
-	// Commit the transaction
-	err = tx.Commit()
+	// Delete the filters and pending digests of the duplicate subscriptions
+	err := f.deleteOrphanRows(tx)
 	if err != nil {
-		f.log.Println("Error while committing the transaction:", err)
+		// Error was already logged
+		return err
+	}
+
+	// Delete the old feed, which has no subscriptions anymore
+	err = f.deleteFeedIfUnused(fromId, tx)
+	if err != nil {
+		// Error was already logged
+		return err
+	}
+
+	// Update the interval of the target feed, as it has new subscribers
+	err = f.updateFeedInterval(toId, tx)
+	if err != nil {
+		// Error was already logged
 		return err
 	}
 
@@ -203,6 +427,7 @@ func (f *Feeds) ListSubscriptions(chatId int64) ([]models.Feed, error) {
 }
 
 // GetFeedByURL returns a feed from its URL, or 0 if it's not present
+// Feeds are matched by their canonical URL, and a feed stored with the other scheme (http or https) matches too, preferring https
 // The transaction is optional
 func (f *Feeds) GetFeedByURL(url string, tx *sqlx.Tx) (*models.Feed, error) {
 	// Use a transaction if we have one
@@ -212,8 +437,44 @@ func (f *Feeds) GetFeedByURL(url string, tx *sqlx.Tx) (*models.Feed, error) {
 	}
 
 	// Run the query
+	urls := utils.URLSchemeVariants(utils.CanonicalURL(url))
+	query, args, err := sqlx.In("SELECT * FROM feeds WHERE feed_canonical_url IN (?) ORDER BY feed_canonical_url LIKE 'https:%' DESC LIMIT 1", urls)
+	if err != nil {
+		f.log.Println("Error building the query:", err)
+		return nil, err
+	}
 	feed := &models.Feed{}
-	err := sqlx.Get(querier, feed, "SELECT * FROM feeds WHERE feed_url = ?", url)
+	err = sqlx.Get(querier, feed, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// No rows found, so record doesn't exist
+			return nil, nil
+		}
+		f.log.Println("Error querying the database:", err)
+		return nil, err
+	}
+
+	return feed, nil
+}
+
+// Requests a feed that was found on a web page, unless it exists already
+func (f *Feeds) requestDiscoveredFeed(pageUrl string, feedUrl string) (*models.Feed, []*gofeed.Item, error) {
+	f.log.Printf("Found feed %s on page %s\n", feedUrl, pageUrl)
+	feed, err := f.GetFeedByURL(feedUrl, nil)
+	if err != nil {
+		// Error was already logged
+		return nil, nil, err
+	}
+	if feed != nil {
+		return feed, nil, nil
+	}
+	return f.requestNewFeed(feedUrl, false)
+}
+
+// Returns a feed from its ID, or nil if it's not present
+func (f *Feeds) getFeedByID(feedId int64) (*models.Feed, error) {
+	feed := &models.Feed{}
+	err := db.GetDB().Get(feed, "SELECT * FROM feeds WHERE feed_id = ?", feedId)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// No rows found, so record doesn't exist
@@ -227,68 +488,144 @@ func (f *Feeds) GetFeedByURL(url string, tx *sqlx.Tx) (*models.Feed, error) {
 }
 
 // AddFeed adds a new feed
+// If the URL is a web page that links to a single feed, that feed is added instead
 // The transaction is optional
 func (f *Feeds) AddFeed(url string, tx *sqlx.Tx) (*models.Feed, error) {
-	// Use a transaction if we have one
-	var querier sqlx.Ext = db.GetDB()
-	if tx != nil {
-		querier = tx
+	feed, _, err := f.addFeed(url, tx, true)
+	return feed, err
+}
+
+// Adds a new feed, returning its items too, sorted from old to new
+// The items are nil if the feed was found in the database
+func (f *Feeds) addFeed(url string, tx *sqlx.Tx, discover bool) (*models.Feed, []*gofeed.Item, error) {
+	feed, items, err := f.requestNewFeed(url, discover)
+	if err != nil {
+		// Error was already logged
+		return nil, nil, err
 	}
+	if feed.ID > 0 {
+		return feed, items, nil
+	}
+	err = f.insertFeed(feed, items, tx)
+	if err != nil {
+		// Error was already logged
+		return nil, nil, err
+	}
+	return feed, items, nil
+}
 
+// Requests a feed that isn't in the database, returning its items too, sorted from old to new
+// The feed isn't stored, so its ID is 0, unless it turns out to be a feed we have already (for example, because it moved); in that case, the items are nil if the feed wasn't requested
+func (f *Feeds) requestNewFeed(url string, discover bool) (*models.Feed, []*gofeed.Item, error) {
 	// Get the feed to both validate it and to get the latest entry
 	f.log.Println("Fetching feed", url)
 	feed := &models.Feed{
-		Url:   url,
-		Title: url,
+		Url:         url,
+		Title:       url,
+		Metadata:    models.FeedMetadataFallback,
+		TitlePolicy: models.FeedTitleFeed,
 	}
 	posts, err := f.RequestFeed(feed)
+	if discover && errors.Is(err, gofeed.ErrFeedTypeNotDetected) {
+		// The URL could be a web page, so look for the feeds it links to
+		err = f.discoverPageFeeds(err)
+		var discoveryErr *FeedDiscoveryError
+		if errors.As(err, &discoveryErr) && len(discoveryErr.Candidates) == 1 {
+			return f.requestDiscoveredFeed(url, discoveryErr.Candidates[0].Url)
+		}
+	}
 	if err != nil {
 		f.log.Printf("Error while fetching feed %d: %s\n", feed.ID, err)
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Get the most recent, valid entry
-	if posts != nil && posts.Items != nil {
-		for _, el := range posts.Items {
-			// Check if this is newer than the one stored
-			if el != nil && el.PublishedParsed != nil && el.PublishedParsed.After(feed.LastPostDate) {
-				p := Post{
-					Title: el.Title,
-					Link:  el.Link,
-					Date:  *el.PublishedParsed,
-				}
-
-				// Request the metadata for the post
-				f.RequestMetadata(&p)
-
-				feed.LastPostTitle = p.Title
-				feed.LastPostLink = p.Link
-				feed.LastPostDate = p.Date
-				feed.LastPostPhoto = p.Photo
+	// If the feed has moved permanently, store the new URL; if we have that already, return the existing feed
+	if feed.MovedTo != "" {
+		existing, err := f.GetFeedByURL(feed.MovedTo, nil)
+		if err != nil {
+			// Error was already logged
+			return nil, nil, err
+		}
+		if existing != nil {
+			f.log.Printf("Feed %s moved to %s, which exists already with ID %d", url, feed.MovedTo, existing.ID)
+			if posts != nil {
+				return existing, posts.Items, nil
 			}
+			return existing, nil, nil
 		}
+		feed.Url = feed.MovedTo
+		feed.MovedTo = ""
+	}
+
+	// The feed must have at least one valid entry
+	if posts == nil || len(posts.Items) == 0 {
+		f.log.Printf("Feed %s doesn't have any valid post\n", feed.Url)
+		return nil, nil, &FetchError{Kind: FetchErrorNoItems}
 	}
 
+	// Get the most recent entry; items are sorted from old to new
+	p := newPost(posts.Items[len(posts.Items)-1])
+
+	// Request the metadata for the post
+	f.RequestMetadata(&p, feed)
+
+	feed.LastPostTitle = p.Title
+	feed.LastPostLink = p.Link
+	feed.LastPostDate = p.Date
+	feed.LastPostPhoto = p.Photo
+
 	// Get the feed's title
-	if posts != nil && posts.Title != "" {
+	if posts.Title != "" {
 		feed.Title = posts.Title
 	}
 
+	return feed, posts.Items, nil
+}
+
+// Stores a feed returned by requestNewFeed in the database, adding its items to the list of seen ones so they're not sent as new posts
+// The transaction is optional
+func (f *Feeds) insertFeed(feed *models.Feed, items []*gofeed.Item, tx *sqlx.Tx) error {
+	// Use a transaction if we have one
+	var querier sqlx.Ext = db.GetDB()
+	if tx != nil {
+		querier = tx
+	}
+
+	// Schedule the next fetch
+	feed.NextFetchAt = nextFetchTime(f.defaultInterval)
+	feed.LastSuccess = time.Now().UTC()
+
 	// Add the feed to the database
-	res, err := querier.Exec("INSERT INTO feeds (feed_url, feed_title, feed_last_modified, feed_etag, feed_last_post_title, feed_last_post_link, feed_last_post_date, feed_last_post_photo) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", feed.Url, feed.Title, feed.LastModified, feed.ETag, feed.LastPostTitle, feed.LastPostLink, feed.LastPostDate, feed.LastPostPhoto)
+	// The source was set by RequestFeed
+	// The canonical URL is used only to find duplicates, while the feed is requested with the URL that was given
+	feed.CanonicalUrl = utils.CanonicalURL(feed.Url)
+	res, err := querier.Exec("INSERT INTO feeds (feed_url, feed_canonical_url, feed_title, feed_source, feed_last_modified, feed_etag, feed_last_post_title, feed_last_post_link, feed_last_post_date, feed_last_post_photo, feed_next_fetch_at, feed_last_success) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", feed.Url, feed.CanonicalUrl, feed.Title, feed.Source, feed.LastModified, feed.ETag, feed.LastPostTitle, feed.LastPostLink, feed.LastPostDate, feed.LastPostPhoto, feed.NextFetchAt, feed.LastSuccess)
 	if err != nil {
 		f.log.Println("Error inserting in the database:", err)
-		return nil, err
+		return err
 	}
 	feed.ID, err = res.LastInsertId()
 	if err != nil {
 		f.log.Println("Error getting the last rowid:", err)
-		return nil, err
+		return err
 	}
 	if feed.ID < 1 {
-		return nil, errors.New("Empty feed ID")
+		return errors.New("Empty feed ID")
 	}
-	f.log.Printf("Added feed %s with ID %d", url, feed.ID)
+	f.log.Printf("Added feed %s with ID %d (source: %s)", feed.Url, feed.ID, feed.Source)
 
-	return feed, nil
+	// Add all current items to the list of seen ones, so they're not sent as new posts
+	keys := make([]string, 0, len(items))
+	for _, el := range items {
+		if el != nil {
+			keys = append(keys, itemKey(el))
+		}
+	}
+	err = f.markSeenItems(feed.ID, keys, len(items), tx)
+	if err != nil {
+		// Error was already logged
+		return err
+	}
+
+	return nil
 }
@@ -0,0 +1,265 @@
+package feeds
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+// Error returned when the subscription doesn't exist
+var ErrSubscriptionNotFound = errors.New("subscription_not_found")
+
+// A filter with its pattern compiled, ready for matching posts
+type compiledFilter struct {
+	models.Filter
+	re *regexp.Regexp
+}
+
+// NewFilter returns a new filter after validating it
+// Patterns wrapped in slashes, like `/pattern/`, are regular expressions; otherwise, they are keywords matched case-insensitively
+func NewFilter(action string, field string, pattern string) (*models.Filter, error) {
+	switch action {
+	case models.FilterActionInclude, models.FilterActionExclude:
+	default:
+		return nil, fmt.Errorf("invalid action '%s'", action)
+	}
+
+	if field == "" {
+		field = models.FilterFieldAny
+	}
+	switch field {
+	case models.FilterFieldAny, models.FilterFieldTitle, models.FilterFieldLink, models.FilterFieldCategory, models.FilterFieldAuthor:
+	default:
+		return nil, fmt.Errorf("invalid field '%s'", field)
+	}
+
+	filter := &models.Filter{
+		Action:  action,
+		Field:   field,
+		Pattern: strings.TrimSpace(pattern),
+	}
+	if len(filter.Pattern) > 2 && strings.HasPrefix(filter.Pattern, "/") && strings.HasSuffix(filter.Pattern, "/") {
+		filter.Pattern = filter.Pattern[1 : len(filter.Pattern)-1]
+		filter.Regex = true
+		_, err := regexp.Compile(filter.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %s", err)
+		}
+	}
+	if filter.Pattern == "" {
+		return nil, errors.New("empty pattern")
+	}
+
+	return filter, nil
+}
+
+// Compiles a list of filters
+// Filters with invalid regular expressions are skipped
+func compileFilters(filters []models.Filter) []compiledFilter {
+	res := make([]compiledFilter, 0, len(filters))
+	for _, filter := range filters {
+		cf := compiledFilter{Filter: filter}
+		if filter.Regex {
+			re, err := regexp.Compile(filter.Pattern)
+			if err != nil {
+				continue
+			}
+			cf.re = re
+		} else {
+			cf.Pattern = strings.ToLower(filter.Pattern)
+		}
+		res = append(res, cf)
+	}
+	return res
+}
+
+// Returns true if the filter matches a value
+func (cf *compiledFilter) matchValue(val string) bool {
+	if val == "" {
+		return false
+	}
+	if cf.re != nil {
+		return cf.re.MatchString(val)
+	}
+	return strings.Contains(strings.ToLower(val), cf.Pattern)
+}
+
+// Returns true if the filter matches the post
+func (cf *compiledFilter) match(post *Post) bool {
+	if (cf.Field == models.FilterFieldAny || cf.Field == models.FilterFieldTitle) && cf.matchValue(post.Title) {
+		return true
+	}
+	if (cf.Field == models.FilterFieldAny || cf.Field == models.FilterFieldLink) && cf.matchValue(post.Link) {
+		return true
+	}
+	if (cf.Field == models.FilterFieldAny || cf.Field == models.FilterFieldAuthor) && cf.matchValue(post.Author) {
+		return true
+	}
+	if cf.Field == models.FilterFieldAny || cf.Field == models.FilterFieldCategory {
+		for _, c := range post.Categories {
+			if cf.matchValue(c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if the post passes the filters
+// If there are "include" filters, the post must match at least one of them; it must not match any "exclude" filter
+func matchFilters(filters []compiledFilter, post *Post) bool {
+	hasInclude := false
+	included := false
+	for i := range filters {
+		switch filters[i].Action {
+		case models.FilterActionExclude:
+			if filters[i].match(post) {
+				return false
+			}
+		case models.FilterActionInclude:
+			hasInclude = true
+			if !included && filters[i].match(post) {
+				included = true
+			}
+		}
+	}
+	return !hasInclude || included
+}
+
+// Returns the ID of the subscription of a chat to a feed
+func (f *Feeds) getSubscriptionID(feedId int64, chatId int64, querier sqlx.Queryer) (int64, error) {
+	subscription := &models.Subscription{}
+	err := sqlx.Get(querier, subscription, "SELECT subscription_id FROM subscriptions WHERE feed_id = ? AND chat_id = ? LIMIT 1", feedId, chatId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrSubscriptionNotFound
+		}
+		f.log.Println("Error querying the database:", err)
+		return 0, err
+	}
+	return subscription.ID, nil
+}
+
+// ListFilters returns the filters for the subscription of a chat to a feed
+func (f *Feeds) ListFilters(feedId int64, chatId int64) ([]models.Filter, error) {
+	DB := db.GetDB()
+
+	subscriptionId, err := f.getSubscriptionID(feedId, chatId, DB)
+	if err != nil {
+		return nil, err
+	}
+
+	// Query the DB
+	rows := []models.Filter{}
+	err = DB.Select(&rows, "SELECT * FROM filters WHERE subscription_id = ? ORDER BY filter_id ASC", subscriptionId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// AddFilter adds a filter to the subscription of a chat to a feed
+func (f *Feeds) AddFilter(feedId int64, chatId int64, filter *models.Filter) error {
+	DB := db.GetDB()
+
+	subscriptionId, err := f.getSubscriptionID(feedId, chatId, DB)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("INSERT INTO filters (subscription_id, filter_action, filter_field, filter_pattern, filter_regex) VALUES (?, ?, ?, ?, ?)", subscriptionId, filter.Action, filter.Field, filter.Pattern, filter.Regex)
+	if err != nil {
+		f.log.Println("Error inserting in the database:", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteFilter removes a filter from the subscription of a chat to a feed
+func (f *Feeds) DeleteFilter(feedId int64, chatId int64, filterId int64) error {
+	DB := db.GetDB()
+
+	subscriptionId, err := f.getSubscriptionID(feedId, chatId, DB)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM filters WHERE filter_id = ? AND subscription_id = ?", filterId, subscriptionId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	return nil
+}
+
+// ClearFilters removes all filters from the subscription of a chat to a feed
+func (f *Feeds) ClearFilters(feedId int64, chatId int64) error {
+	DB := db.GetDB()
+
+	subscriptionId, err := f.getSubscriptionID(feedId, chatId, DB)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM filters WHERE subscription_id = ?", subscriptionId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	return nil
+}
+
+// Returns the compiled filters for all subscriptions to a feed, keyed by subscription ID
+func (f *Feeds) loadFeedFilters(feedId int64, querier sqlx.Queryer) (map[int64][]compiledFilter, error) {
+	rows := []models.Filter{}
+	err := sqlx.Select(querier, &rows, "SELECT filters.* FROM filters, subscriptions WHERE subscriptions.feed_id = ? AND filters.subscription_id = subscriptions.subscription_id ORDER BY filter_id ASC", feedId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return nil, err
+	}
+
+	// Group by subscription
+	grouped := make(map[int64][]models.Filter)
+	for _, row := range rows {
+		grouped[row.SubscriptionID] = append(grouped[row.SubscriptionID], row)
+	}
+	res := make(map[int64][]compiledFilter, len(grouped))
+	for id, filters := range grouped {
+		res[id] = compileFilters(filters)
+	}
+	return res, nil
+}
+
+// Returns true if the post passes the filters of at least one of the subscriptions
+func anySubscriptionMatches(subs []int64, filters map[int64][]compiledFilter, post *Post) bool {
+	for _, id := range subs {
+		if len(filters[id]) == 0 || matchFilters(filters[id], post) {
+			return true
+		}
+	}
+	return false
+}
+
+// Deletes filters and posts waiting for a digest for subscriptions that don't exist anymore
+func (f *Feeds) deleteOrphanRows(tx *sqlx.Tx) error {
+	_, err := tx.Exec("DELETE FROM filters WHERE subscription_id NOT IN (SELECT subscription_id FROM subscriptions)")
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM digest_items WHERE subscription_id NOT IN (SELECT subscription_id FROM subscriptions)")
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	return nil
+}
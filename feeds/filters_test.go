@@ -0,0 +1,102 @@
+package feeds
+
+import (
+	"testing"
+
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+func TestMatchFilters(t *testing.T) {
+	post := &Post{
+		Title:      "Announcing Go 1.20",
+		Link:       "https://go.dev/blog/go1.20",
+		Author:     "The Go Team",
+		Categories: []string{"release", "golang"},
+	}
+
+	newFilter := func(action, field, pattern string) models.Filter {
+		f, err := NewFilter(action, field, pattern)
+		if err != nil {
+			t.Fatalf("Error creating filter %s %s %s: %s", action, field, pattern, err)
+		}
+		return *f
+	}
+
+	cases := []struct {
+		name    string
+		filters []models.Filter
+		out     bool
+	}{
+		{"no filters", nil, true},
+		{"include keyword", []models.Filter{newFilter("include", "", "go 1.20")}, true},
+		{"include keyword not matching", []models.Filter{newFilter("include", "", "rust")}, false},
+		{"include one of many", []models.Filter{newFilter("include", "", "rust"), newFilter("include", "", "announcing")}, true},
+		{"exclude keyword", []models.Filter{newFilter("exclude", "", "ANNOUNCING")}, false},
+		{"exclude wins over include", []models.Filter{newFilter("include", "", "go"), newFilter("exclude", "author", "team")}, false},
+		{"field title", []models.Filter{newFilter("include", "title", "go.dev")}, false},
+		{"field link", []models.Filter{newFilter("include", "link", "go.dev")}, true},
+		{"field category", []models.Filter{newFilter("include", "category", "release")}, true},
+		{"regex", []models.Filter{newFilter("include", "title", `/^Announcing Go \d+\.\d+$/`)}, true},
+		{"regex is case-sensitive", []models.Filter{newFilter("include", "title", `/^announcing/`)}, false},
+		{"regex case-insensitive", []models.Filter{newFilter("include", "title", `/(?i)^announcing/`)}, true},
+	}
+
+	for _, el := range cases {
+		res := matchFilters(compileFilters(el.filters), post)
+		if res != el.out {
+			t.Fatalf("Expected result for '%s' to be %v, but got %v", el.name, el.out, res)
+		}
+	}
+}
+
+func TestNewFilterInvalid(t *testing.T) {
+	cases := []struct {
+		action  string
+		field   string
+		pattern string
+	}{
+		{"allow", "", "go"},
+		{"include", "body", "go"},
+		{"include", "", ""},
+		{"include", "", "/(/"},
+	}
+
+	for _, el := range cases {
+		_, err := NewFilter(el.action, el.field, el.pattern)
+		if err == nil {
+			t.Fatalf("Expected an error for filter %s %s %s", el.action, el.field, el.pattern)
+		}
+	}
+}
+
+func TestAnySubscriptionMatches(t *testing.T) {
+	post := &Post{
+		Title: "Announcing Go 1.20",
+		Link:  "https://go.dev/blog/go1.20",
+	}
+
+	include, _ := NewFilter("include", "", "go")
+	exclude, _ := NewFilter("exclude", "", "go")
+	filters := map[int64][]compiledFilter{
+		1: compileFilters([]models.Filter{*include}),
+		2: compileFilters([]models.Filter{*exclude}),
+	}
+
+	cases := []struct {
+		name string
+		subs []int64
+		out  bool
+	}{
+		{"no subscriptions", nil, false},
+		{"matching filters", []int64{1, 2}, true},
+		{"no matching filters", []int64{2}, false},
+		{"subscription without filters", []int64{2, 3}, true},
+	}
+
+	for _, el := range cases {
+		res := anySubscriptionMatches(el.subs, filters, post)
+		if res != el.out {
+			t.Fatalf("Expected result for '%s' to be %v, but got %v", el.name, el.out, res)
+		}
+	}
+}
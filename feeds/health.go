@@ -0,0 +1,140 @@
+package feeds
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mmcdole/gofeed"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+// Interval for fetching paused feeds, to check if they're working again
+const pausedFetchInterval = 24 * time.Hour
+
+// Returns true if the error means that the feed is gone for good
+func isFeedGone(err error) bool {
+	httpErr := gofeed.HTTPError{}
+	return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusGone
+}
+
+// Records the result of fetching a feed, updating its health status
+// Subscribers are notified when the feed has been failing for too long, when it's paused, and when it's working again
+func (f *Feeds) recordFetchResult(feed *models.Feed, fetchErr error) error {
+	var message string
+	now := time.Now().UTC()
+
+	if fetchErr == nil {
+		// If the feed wasn't failing, there's nothing to do
+		if feed.Failures == 0 && !feed.Paused {
+			feed.LastSuccess = now
+			_, err := db.GetDB().Exec("UPDATE feeds SET feed_last_success = ? WHERE feed_id = ?", feed.LastSuccess, feed.ID)
+			if err != nil {
+				f.log.Println("Error querying the database:", err)
+				return err
+			}
+			return nil
+		}
+
+		// Let subscribers know if we told them the feed was broken
+		if feed.Notified || feed.Paused {
+			message = fmt.Sprintf("✅ The feed %s is working again", feed.Url)
+		}
+		feed.Failures = 0
+		feed.LastError = ""
+		feed.LastSuccess = now
+		feed.Notified = false
+		feed.Paused = false
+	} else {
+		feed.Failures++
+		feed.LastError = fetchErr.Error()
+
+		failing := now.Sub(feed.LastSuccess)
+		switch {
+		case feed.Paused:
+			// Already paused, nothing to tell subscribers
+		case isFeedGone(fetchErr):
+			feed.Paused = true
+			message = fmt.Sprintf("⏸ The feed %s doesn't exist anymore (the server responded with \"410 Gone\"), so it was paused. I'll check it once a day and resume it if it's back. You can also remove it with /remove.", feed.Url)
+		case failing >= f.pauseAfter:
+			feed.Paused = true
+			message = fmt.Sprintf("⏸ The feed %s has been failing for %s, so it was paused. I'll check it once a day and resume it when it works again. You can also remove it with /remove.\nLast error: %s", feed.Url, formatDuration(failing), feed.LastError)
+		case failing >= f.failureNotifyAfter && !feed.Notified:
+			feed.Notified = true
+			message = fmt.Sprintf("⚠️ The feed %s has been failing for %s.\nLast error: %s", feed.Url, formatDuration(failing), feed.LastError)
+		}
+	}
+
+	// Begin a transaction
+	tx, err := db.GetDB().Beginx()
+	if err != nil {
+		f.log.Println("Error starting a transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Update the feed
+	_, err = tx.Exec("UPDATE feeds SET feed_failures = ?, feed_last_error = ?, feed_last_success = ?, feed_failure_notified = ?, feed_paused = ? WHERE feed_id = ?", feed.Failures, feed.LastError, feed.LastSuccess, feed.Notified, feed.Paused, feed.ID)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	// Notify subscribers
+	if message != "" {
+		err = f.notifySubscribersText(feed, message, tx)
+		if err != nil {
+			// Error was already logged
+			return err
+		}
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		f.log.Println("Error while committing the transaction:", err)
+		return err
+	}
+
+	if message != "" {
+		f.notifyOutbox()
+	}
+
+	return nil
+}
+
+// Queues a text message in the outbox for all subscribers of a feed
+func (f *Feeds) notifySubscribersText(feed *models.Feed, message string, tx *sqlx.Tx) error {
+	chatIds := []int64{}
+	err := tx.Select(&chatIds, "SELECT chat_id FROM subscriptions WHERE feed_id = ?", feed.ID)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	for _, chatId := range chatIds {
+		err = f.queueText(chatId, feed.ID, message, tx)
+		if err != nil {
+			// Error was already logged
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns a human-readable string for a duration, rounded to hours or days
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	default:
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	}
+}
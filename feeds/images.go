@@ -0,0 +1,318 @@
+package feeds
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// Decoders for the supported image formats
+	_ "image/gif"
+	_ "image/png"
+
+	_ "github.com/gen2brain/avif"
+	"github.com/spf13/viper"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Time after which downloaded images are removed from the cache
+const imageCacheTTL = time.Hour
+
+// Default maximum size of downloaded images, used if MaxImageSize isn't set
+const defaultMaxImageSize = 20 << 20
+
+// Number of bytes at the beginning of a file in which we look for the <svg> tag
+const svgSniffLength = 1024
+
+// Limits for photos uploaded to Telegram
+const (
+	// Maximum size of the uploaded file
+	maxPhotoSize = 10 << 20
+	// Maximum width and height; larger images are resized, as Telegram would scale them down anyway
+	maxPhotoDimension = 2560
+	// Maximum ratio between width and height
+	maxPhotoRatio = 20
+	// Maximum number of pixels of images that are decoded, as decoding allocates memory for all of them
+	maxPhotoPixels = 50_000_000
+)
+
+// Quality for encoding JPEG images
+const photoJPEGQuality = 85
+
+// Error returned when the image is in a format that can't be converted
+// Supported formats are JPEG, PNG, GIF, WebP, AVIF and SVG
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// DownloadImage downloads an image and converts it to a JPEG that can be uploaded to Telegram
+// This is used for images that Telegram can't fetch by URL itself, such as those that are too big, behind hotlink protection, or in formats like WebP, AVIF and SVG
+// Images are cached on disk for a short time, so the same image sent to multiple chats is downloaded only once
+func (f *Feeds) DownloadImage(url string) ([]byte, error) {
+	// Check if the image is in the cache
+	cachePath := f.imageCachePath(url)
+	if cachePath != "" {
+		info, err := os.Stat(cachePath)
+		if err == nil && time.Since(info.ModTime()) < imageCacheTTL {
+			data, err := os.ReadFile(cachePath)
+			if err == nil {
+				return data, nil
+			}
+		}
+	}
+
+	// Request the image
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(f.ctx)
+	req.Header.Set("User-Agent", "RSSBot/1.0")
+	req.Header.Set("Accept", "image/jpeg,image/png,image/gif,image/webp,image/avif,image/svg+xml,image/*;q=0.8")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("response status code is %d", resp.StatusCode)
+	}
+
+	// Read the image, up to the maximum size
+	maxSize := maxImageSize()
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("image is too big: %d bytes", resp.ContentLength)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("image is bigger than %d bytes", maxSize)
+	}
+
+	// Convert the image
+	data, err := convertPhoto(body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the image in the cache, writing to a temporary file first so other readers never see partial files
+	// Errors are logged only
+	if cachePath != "" {
+		err = writeFileAtomic(cachePath, data)
+		if err != nil {
+			f.log.Printf("Error while storing image %s in the cache: %s\n", url, err)
+		}
+	}
+
+	return data, nil
+}
+
+// Converts an image to a JPEG within Telegram's limits for photos
+func convertPhoto(body []byte) ([]byte, error) {
+	img, err := decodePhoto(body)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// Resize the image if needed, and draw it over a white background, as JPEG doesn't support transparency
+	if w > maxPhotoDimension || h > maxPhotoDimension {
+		if w > h {
+			h = h * maxPhotoDimension / w
+			w = maxPhotoDimension
+		} else {
+			w = w * maxPhotoDimension / h
+			h = maxPhotoDimension
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+
+	// Encode as JPEG, lowering the quality if the result is too big
+	var buf bytes.Buffer
+	for quality := photoJPEGQuality; quality > 0; quality -= 20 {
+		buf.Reset()
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality})
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() <= maxPhotoSize {
+			return buf.Bytes(), nil
+		}
+	}
+	return nil, fmt.Errorf("image is too big after conversion: %d bytes", buf.Len())
+}
+
+// Decodes an image, checking its size before decoding it
+// SVG images are rendered at their own size, scaled down if they're bigger than the maximum size of photos
+func decodePhoto(body []byte) (image.Image, error) {
+	if isSVG(body) {
+		return decodeSVG(body)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err == image.ErrFormat {
+		return nil, ErrUnsupportedImage
+	} else if err != nil {
+		return nil, err
+	}
+	err = checkPhotoSize(cfg.Width, cfg.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// Renders a SVG image
+func decodeSVG(body []byte) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	w, h := int(icon.ViewBox.W), int(icon.ViewBox.H)
+	err = checkPhotoSize(w, h)
+	if err != nil {
+		return nil, err
+	}
+	if w > maxPhotoDimension || h > maxPhotoDimension {
+		if w > h {
+			h = h * maxPhotoDimension / w
+			w = maxPhotoDimension
+		} else {
+			w = w * maxPhotoDimension / h
+			h = maxPhotoDimension
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.SetTarget(0, 0, float64(w), float64(h))
+	scanner := rasterx.NewScannerGV(w, h, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(w, h, scanner), 1)
+	return img, nil
+}
+
+// Returns true if the data is a SVG image
+func isSVG(body []byte) bool {
+	head := body
+	if len(head) > svgSniffLength {
+		head = head[:svgSniffLength]
+	}
+	head = bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")))
+	return bytes.HasPrefix(head, []byte("<")) && bytes.Contains(head, []byte("<svg"))
+}
+
+// Returns an error if an image with the given size can't be converted to a photo
+// This is checked before decoding images, as decoding allocates memory for all of their pixels
+func checkPhotoSize(w, h int) error {
+	if w < 1 || h < 1 {
+		return errors.New("image is empty")
+	}
+	if w > h*maxPhotoRatio || h > w*maxPhotoRatio {
+		return fmt.Errorf("image's aspect ratio is not supported: %dx%d", w, h)
+	}
+	if int64(w)*int64(h) > maxPhotoPixels {
+		return fmt.Errorf("image has too many pixels: %dx%d", w, h)
+	}
+	return nil
+}
+
+// Returns the maximum size of downloaded images
+func maxImageSize() int64 {
+	size := viper.GetInt64("MaxImageSize")
+	if size <= 0 {
+		return defaultMaxImageSize
+	}
+	return size
+}
+
+// Returns the directory where downloaded images are cached, creating it if needed
+// If the cache can't be used, it returns an empty string
+func (f *Feeds) imageCacheDir() string {
+	dir := viper.GetString("ImageCacheDir")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "rss-bot-images")
+	}
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		f.log.Printf("Error while creating the image cache directory %s: %s\n", dir, err)
+		return ""
+	}
+	return dir
+}
+
+// Returns the path of the cached file for an image
+func (f *Feeds) imageCachePath(url string) string {
+	dir := f.imageCacheDir()
+	if dir == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(url))
+	return filepath.Join(dir, hex.EncodeToString(h[:])+".jpg")
+}
+
+// Writes a file by writing to a temporary file in the same directory first, then renaming it
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Removes expired images from the cache
+// This doesn't return errors but it only logs them
+func (f *Feeds) pruneImageCache() {
+	dir := f.imageCacheDir()
+	if dir == "" {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		f.log.Println("Error while pruning the image cache:", err)
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".jpg") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < imageCacheTTL {
+			continue
+		}
+		err = os.Remove(filepath.Join(dir, e.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			f.log.Println("Error while pruning the image cache:", err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package feeds
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/gen2brain/avif"
+)
+
+// Returns a PNG image with the given size
+func testPNG(t *testing.T, w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	img.Set(0, 0, color.NRGBA{R: 255, A: 128})
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestConvertPhoto(t *testing.T) {
+	cases := []struct {
+		w, h  int
+		outW  int
+		outH  int
+		isErr bool
+	}{
+		{100, 50, 100, 50, false},
+		{5120, 2560, 2560, 1280, false},
+		{1000, 4000, 640, 2560, false},
+		{2100, 100, 0, 0, true},
+	}
+
+	for _, el := range cases {
+		res, err := convertPhoto(testPNG(t, el.w, el.h))
+		if el.isErr {
+			if err == nil {
+				t.Fatalf("Expected an error for %dx%d", el.w, el.h)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Expected no error for %dx%d, but got %s", el.w, el.h, err)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(res))
+		if err != nil {
+			t.Fatalf("Expected a JPEG image for %dx%d, but got error %s", el.w, el.h, err)
+		}
+		if cfg.Width != el.outW || cfg.Height != el.outH {
+			t.Fatalf("Expected size for %dx%d to be %dx%d, but got %dx%d", el.w, el.h, el.outW, el.outH, cfg.Width, cfg.Height)
+		}
+	}
+
+	// Images with too many pixels are rejected before decoding them
+	// This is the header of a 20000x20000 GIF, without any data
+	_, err := convertPhoto([]byte("GIF89a\x20\x4e\x20\x4e\x00\x00\x00"))
+	if err == nil || err == ErrUnsupportedImage {
+		t.Fatalf("Expected an error for an image with too many pixels, but got %v", err)
+	}
+
+	// AVIF and SVG images
+	var avifBuf bytes.Buffer
+	err = avif.Encode(&avifBuf, image.NewNRGBA(image.Rect(0, 0, 64, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	others := []struct {
+		name string
+		data []byte
+		outW int
+		outH int
+	}{
+		{"avif", avifBuf.Bytes(), 64, 32},
+		{"svg", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 200 100"><rect x="10" y="10" width="50" height="50" fill="red"/></svg>`), 200, 100},
+		{"large svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="5120" height="1280"></svg>`), 2560, 640},
+	}
+	for _, el := range others {
+		res, err := convertPhoto(el.data)
+		if err != nil {
+			t.Fatalf("Expected no error for %s, but got %s", el.name, err)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(res))
+		if err != nil {
+			t.Fatalf("Expected a JPEG image for %s, but got error %s", el.name, err)
+		}
+		if cfg.Width != el.outW || cfg.Height != el.outH {
+			t.Fatalf("Expected size for %s to be %dx%d, but got %dx%d", el.name, el.outW, el.outH, cfg.Width, cfg.Height)
+		}
+	}
+
+	// Unsupported formats
+	_, err = convertPhoto([]byte("not an image"))
+	if err != ErrUnsupportedImage {
+		t.Fatalf("Expected ErrUnsupportedImage, but got %v", err)
+	}
+}
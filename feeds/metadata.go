@@ -1,69 +1,408 @@
 package feeds
 
 import (
+	"database/sql"
+	"errors"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
 	opengraph "github.com/otiai10/opengraph/v2"
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+	"github.com/ItalyPaleAle/rss-bot/models"
 )
 
+// Default maximum number of bytes read from web pages, used if MaxPageSize isn't set
+const defaultMaxPageSize = 1 << 20
+
+// Time after which the metadata of a link is requested again
+const linkMetadataTTL = 24 * time.Hour
+
+// Time after which the metadata of a link is requested again, if the previous request failed
+const linkMetadataFailureTTL = time.Hour
+
+// Errors returned when the options for requesting the metadata are not valid
+var (
+	ErrInvalidMetadataMode = errors.New("invalid metadata mode")
+	ErrInvalidTitlePolicy  = errors.New("invalid title policy")
+)
+
+// ParseMetadataMode parses the mode for requesting the metadata of posts: "always", "fallback" or "never"
+func ParseMetadataMode(str string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(str)); mode {
+	case models.FeedMetadataAlways, models.FeedMetadataFallback, models.FeedMetadataNever:
+		return mode, nil
+	default:
+		return "", ErrInvalidMetadataMode
+	}
+}
+
+// ParseTitlePolicy parses the policy for the title of posts: "feed", "opengraph" (or "og") or "longer"
+func ParseTitlePolicy(str string) (string, error) {
+	switch policy := strings.ToLower(strings.TrimSpace(str)); policy {
+	case models.FeedTitleFeed, models.FeedTitleOpenGraph, models.FeedTitleLonger:
+		return policy, nil
+	case "og":
+		return models.FeedTitleOpenGraph, nil
+	default:
+		return "", ErrInvalidTitlePolicy
+	}
+}
+
+// SetFeedMetadata sets when the web page of the feed's posts is requested to get their metadata
+// This applies to all chats subscribed to the feed
+func (f *Feeds) SetFeedMetadata(feedId int64, mode string) error {
+	return f.setFeedSetting(feedId, "feed_metadata", mode)
+}
+
+// SetFeedTitlePolicy sets which title is used for the feed's posts
+// This applies to all chats subscribed to the feed
+func (f *Feeds) SetFeedTitlePolicy(feedId int64, policy string) error {
+	return f.setFeedSetting(feedId, "feed_title_policy", policy)
+}
+
+// SetFeedOGDescription sets whether the OpenGraph description is used as summary for the feed's posts that don't have one
+// This applies to all chats subscribed to the feed
+func (f *Feeds) SetFeedOGDescription(feedId int64, enabled bool) error {
+	return f.setFeedSetting(feedId, "feed_og_description", enabled)
+}
+
+// Sets the value of a column in the feeds table
+func (f *Feeds) setFeedSetting(feedId int64, column string, value interface{}) error {
+	_, err := db.GetDB().Exec("UPDATE feeds SET "+column+" = ? WHERE feed_id = ?", value, feedId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	return nil
+}
+
 // RequestMetadata requests the web page to get the title, description, and image from the page's metadata itself
+// Depending on the feed's metadata mode, the page is requested always, never, or only if the page could add something to the post: an image if the post doesn't have one from the feed, the title if the feed's title policy uses it, or the summary if that's enabled and the post doesn't have one
+// The metadata is cached for each link, so pages are not requested again for posts that appear in multiple feeds or that were processed already
+// The cache is read and written outside of any transaction, so this must not be called while one is open
 // This method updates the value of the post argument as a side effect
 // Errors are logged only and then ignored
-func (f *Feeds) RequestMetadata(post *Post) {
+func (f *Feeds) RequestMetadata(post *Post, feed *models.Feed) {
+	f.requestMetadata(post, feed, true)
+}
+
+// Requests the metadata of a post like RequestMetadata
+// If useCache is false, the cache isn't used at all, so the database isn't touched
+func (f *Feeds) requestMetadata(post *Post, feed *models.Feed, useCache bool) {
 	if post.Link == "" {
 		return
 	}
+	switch feed.Metadata {
+	case models.FeedMetadataNever:
+		return
+	case models.FeedMetadataAlways:
+		// Nop
+	default:
+		if post.Photo != "" && !usesOGTitle(feed.TitlePolicy) && (!feed.OGDescription || post.Summary != "") {
+			return
+		}
+	}
 
-	// Wrapping this in a method that returns an error
-	err := f.doRequestMetadata(post)
-	if err != nil {
-		f.log.Printf("Error while requesting the page %s: %s\n", post.Link, err)
+	// Look for the link in the cache first
+	var meta *models.LinkMetadata
+	var err error
+	if useCache {
+		meta, err = f.getLinkMetadata(post.Link)
+		if err != nil {
+			// Error was already logged
+			return
+		}
+	}
+	if meta == nil {
+		// Wrapping this in a method that returns an error
+		meta, err = f.doRequestMetadata(post.Link)
+		if err != nil {
+			f.log.Printf("Error while requesting the page %s: %s\n", post.Link, err)
+		}
+
+		// Store the result, including failures, unless the request was canceled because we're shutting down
+		if useCache && f.ctx.Err() == nil {
+			// Ignore errors (already logged)
+			_ = f.saveLinkMetadata(meta)
+		}
+	}
+	if !meta.OK() {
 		return
 	}
+
+	// Update the post's data with information from OpenGraph
+	post.Title = postTitle(post.Title, meta.Title, feed.TitlePolicy)
+	if meta.Image != "" {
+		post.Photo = meta.Image
+	}
+	if feed.OGDescription && post.Summary == "" && meta.Description != "" {
+		// The description is plain text
+		post.Summary = TruncateSummary(sanitizeHTML(escapeHTML(meta.Description)), MaxSummaryLength)
+	}
 }
 
-// Performs
-func (f *Feeds) doRequestMetadata(post *Post) (err error) {
+// Requests the web page and returns its metadata
+// The result is never nil, and if there's an error it contains the status of the response
+func (f *Feeds) doRequestMetadata(link string) (meta *models.LinkMetadata, err error) {
+	meta = &models.LinkMetadata{
+		Link:      link,
+		FetchedAt: time.Now().UTC(),
+	}
+
 	// Request the web page
-	req, err := http.NewRequest("GET", post.Link, nil)
+	req, err := http.NewRequest("GET", link, nil)
 	if err != nil {
-		return err
+		return meta, err
 	}
 	req = req.WithContext(f.ctx)
 	req.Header.Set("User-Agent", "RSSBot/1.0")
 	resp, err := f.client.Do(req)
 	if err != nil {
-		return err
+		return meta, err
 	}
 	defer resp.Body.Close()
 
 	// Status code
+	meta.Status = resp.StatusCode
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return gofeed.HTTPError{
+		return meta, gofeed.HTTPError{
 			StatusCode: resp.StatusCode,
 			Status:     resp.Status,
 		}
 	}
 
-	// Read the response and extract the OpenGraph tags
+	// Read the response, up to the maximum size, and extract the OpenGraph tags
+	// The tags are in the page's head, so they are usually found even if the page is truncated
 	ogp := &opengraph.OpenGraph{}
-	err = ogp.Parse(resp.Body)
+	// Relative URLs are resolved against the final URL, after redirects
+	ogp.Intent.URL = resp.Request.URL.String()
+	err = ogp.Parse(io.LimitReader(resp.Body, maxPageSize()))
 	if err != nil {
-		return err
+		meta.Status = 0
+		return meta, err
 	}
 	err = ogp.ToAbs()
 	if err != nil {
-		return err
+		meta.Status = 0
+		return meta, err
 	}
 
-	// Update the feed's data with information from OpenGraph
-	if ogp.Title != "" {
-		post.Title = ogp.Title
-	}
+	meta.Title = strings.TrimSpace(ogp.Title)
+	meta.Description = strings.TrimSpace(ogp.Description)
 	if len(ogp.Image) > 0 {
-		post.Photo = ogp.Image[0].URL
+		meta.Image = ogp.Image[0].URL
+	}
+
+	return meta, nil
+}
+
+// Returns the metadata of a link from the cache, or nil if it's not cached or it has expired
+func (f *Feeds) getLinkMetadata(link string) (*models.LinkMetadata, error) {
+	meta := &models.LinkMetadata{}
+	err := db.GetDB().Get(meta, "SELECT * FROM link_metadata WHERE link = ?", link)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return nil, err
+	}
+
+	// Failures are cached for a shorter time
+	ttl := linkMetadataTTL
+	if !meta.OK() {
+		ttl = linkMetadataFailureTTL
+	}
+	if time.Since(meta.FetchedAt) >= ttl {
+		return nil, nil
 	}
+	return meta, nil
+}
 
+// Stores the metadata of a link in the cache
+func (f *Feeds) saveLinkMetadata(meta *models.LinkMetadata) error {
+	_, err := db.GetDB().Exec("INSERT OR REPLACE INTO link_metadata (link, link_title, link_image, link_description, link_fetched_at, link_status) VALUES (?, ?, ?, ?, ?, ?)", meta.Link, meta.Title, meta.Image, meta.Description, meta.FetchedAt, meta.Status)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
 	return nil
 }
+
+// Returns the maximum number of bytes read from web pages
+func maxPageSize() int64 {
+	size := viper.GetInt64("MaxPageSize")
+	if size <= 0 {
+		return defaultMaxPageSize
+	}
+	return size
+}
+
+// Removes expired metadata from the cache
+// This doesn't return errors but it only logs them
+func (f *Feeds) pruneLinkMetadata() {
+	before := time.Now().UTC().Add(-linkMetadataTTL)
+	_, err := db.GetDB().Exec("DELETE FROM link_metadata WHERE link_fetched_at < ?", before)
+	if err != nil {
+		f.log.Println("Error while pruning the link metadata:", err)
+	}
+}
+
+// Returns true if the title policy uses the OpenGraph title
+func usesOGTitle(policy string) bool {
+	return policy == models.FeedTitleOpenGraph || policy == models.FeedTitleLonger
+}
+
+// Returns the title of a post, choosing between the one in the feed and the OpenGraph one according to the policy
+// If either title is empty, the other one is used
+func postTitle(feedTitle string, ogTitle string, policy string) string {
+	feedTitle = strings.TrimSpace(feedTitle)
+	ogTitle = strings.TrimSpace(ogTitle)
+	switch {
+	case ogTitle == "":
+		return feedTitle
+	case feedTitle == "":
+		return ogTitle
+	}
+
+	switch policy {
+	case models.FeedTitleOpenGraph:
+		return ogTitle
+	case models.FeedTitleLonger:
+		if utf8.RuneCountInString(ogTitle) > utf8.RuneCountInString(feedTitle) {
+			return ogTitle
+		}
+		return feedTitle
+	default:
+		return feedTitle
+	}
+}
+
+// Returns the URL of the image of an item, as included in the feed, or an empty string if there's none
+// In order, it looks at the item's image, Media RSS elements, image enclosures, the iTunes image, and finally the first image in the content
+// Relative URLs are resolved against the item's link
+func itemImage(el *gofeed.Item) string {
+	var img string
+	switch {
+	case el.Image != nil && el.Image.URL != "":
+		img = el.Image.URL
+	case mediaImage(el.Extensions["media"]) != "":
+		img = mediaImage(el.Extensions["media"])
+	case enclosureImage(el.Enclosures) != "":
+		img = enclosureImage(el.Enclosures)
+	case el.ITunesExt != nil && el.ITunesExt.Image != "":
+		img = el.ITunesExt.Image
+	case htmlImage(el.Content) != "":
+		img = htmlImage(el.Content)
+	default:
+		img = htmlImage(el.Description)
+	}
+	if img == "" {
+		return ""
+	}
+
+	// Resolve the URL, which must be http(s)
+	u, err := url.Parse(strings.TrimSpace(img))
+	if err != nil {
+		return ""
+	}
+	if base, err := url.Parse(el.Link); err == nil {
+		u = base.ResolveReference(u)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
+
+// Returns the URL of the first image in the Media RSS elements of an item
+// Images in media:content are preferred over thumbnails, which are used for videos too
+func mediaImage(media map[string][]ext.Extension) string {
+	if media == nil {
+		return ""
+	}
+
+	// Look at the elements in media:group too
+	contents := media["content"]
+	thumbnails := media["thumbnail"]
+	for _, g := range media["group"] {
+		contents = append(contents, g.Children["content"]...)
+		thumbnails = append(thumbnails, g.Children["thumbnail"]...)
+	}
+
+	for _, c := range contents {
+		if c.Attrs["url"] == "" {
+			continue
+		}
+		if c.Attrs["medium"] == "image" || strings.HasPrefix(c.Attrs["type"], "image/") {
+			return c.Attrs["url"]
+		}
+		// Thumbnails can be nested in media:content, for example for videos
+		for _, t := range c.Children["thumbnail"] {
+			if t.Attrs["url"] != "" {
+				return t.Attrs["url"]
+			}
+		}
+	}
+	for _, t := range thumbnails {
+		if t.Attrs["url"] != "" {
+			return t.Attrs["url"]
+		}
+	}
+	return ""
+}
+
+// Returns the URL of the first enclosure that is an image
+func enclosureImage(enclosures []*gofeed.Enclosure) string {
+	for _, e := range enclosures {
+		if e != nil && e.URL != "" && strings.HasPrefix(e.Type, "image/") {
+			return e.URL
+		}
+	}
+	return ""
+}
+
+// Returns the URL of the first image in a HTML fragment
+func htmlImage(str string) string {
+	if str == "" {
+		return ""
+	}
+	z := html.NewTokenizer(strings.NewReader(str))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// End of the document
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "img" {
+				continue
+			}
+			src := ""
+			tracker := false
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				switch string(key) {
+				case "src":
+					src = strings.TrimSpace(string(val))
+				case "width", "height":
+					// Tracking pixels
+					tracker = tracker || string(val) == "0" || string(val) == "1"
+				}
+			}
+			// Skip data URIs, which are often used as placeholders for lazy-loaded images
+			if src != "" && !tracker && !strings.HasPrefix(src, "data:") {
+				return src
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package feeds
+
+import (
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+func TestItemImage(t *testing.T) {
+	cases := []struct {
+		name  string
+		item  *gofeed.Item
+		image string
+	}{
+		{"none", &gofeed.Item{Link: "https://example.com/post", Content: "<p>Hello</p>"}, ""},
+		{"image", &gofeed.Item{Image: &gofeed.Image{URL: "https://example.com/a.jpg"}, Enclosures: []*gofeed.Enclosure{{URL: "https://example.com/b.jpg", Type: "image/jpeg"}}}, "https://example.com/a.jpg"},
+		{"media content", &gofeed.Item{Extensions: ext.Extensions{"media": {
+			"thumbnail": {{Attrs: map[string]string{"url": "https://example.com/thumb.jpg"}}},
+			"content":   {{Attrs: map[string]string{"url": "https://example.com/video.mp4", "medium": "video"}}, {Attrs: map[string]string{"url": "https://example.com/a.jpg", "medium": "image"}}},
+		}}}, "https://example.com/a.jpg"},
+		{"media thumbnail", &gofeed.Item{Extensions: ext.Extensions{"media": {
+			"content":   {{Attrs: map[string]string{"url": "https://example.com/video.mp4", "type": "video/mp4"}}},
+			"thumbnail": {{Attrs: map[string]string{"url": "https://example.com/thumb.jpg"}}},
+		}}}, "https://example.com/thumb.jpg"},
+		{"media group", &gofeed.Item{Extensions: ext.Extensions{"media": {
+			"group": {{Children: map[string][]ext.Extension{"content": {{Attrs: map[string]string{"url": "https://example.com/a.png", "type": "image/png"}}}}}},
+		}}}, "https://example.com/a.png"},
+		{"enclosure", &gofeed.Item{Enclosures: []*gofeed.Enclosure{{URL: "https://example.com/a.mp3", Type: "audio/mpeg"}, {URL: "https://example.com/b.jpg", Type: "image/jpeg"}}}, "https://example.com/b.jpg"},
+		{"itunes", &gofeed.Item{ITunesExt: &ext.ITunesItemExtension{Image: "https://example.com/cover.jpg"}}, "https://example.com/cover.jpg"},
+		{"content", &gofeed.Item{Link: "https://example.com/posts/1", Content: `<p><img src="data:image/gif;base64,R0lGOD" /><img src="/pixel.gif" width="1" height="1"><img src="../img/a.jpg"></p>`}, "https://example.com/img/a.jpg"},
+		{"description", &gofeed.Item{Description: `<img src="https://example.com/a.webp">`}, "https://example.com/a.webp"},
+		{"not http", &gofeed.Item{Image: &gofeed.Image{URL: "ftp://example.com/a.jpg"}}, ""},
+	}
+
+	for _, el := range cases {
+		res := itemImage(el.item)
+		if res != el.image {
+			t.Errorf("Expected image for case %s to be %q, but got %q", el.name, el.image, res)
+		}
+	}
+}
+
+func TestPostTitle(t *testing.T) {
+	cases := []struct {
+		feedTitle string
+		ogTitle   string
+		policy    string
+		title     string
+	}{
+		{"Post title", "Site name", models.FeedTitleFeed, "Post title"},
+		{"Post title", "Site name", "", "Post title"},
+		{"Post title", "Site name", models.FeedTitleOpenGraph, "Site name"},
+		{"Post title", "A longer title of the post", models.FeedTitleLonger, "A longer title of the post"},
+		{"A longer title of the post", "Site name", models.FeedTitleLonger, "A longer title of the post"},
+		{"", "Site name", models.FeedTitleFeed, "Site name"},
+		{"Post title", " ", models.FeedTitleOpenGraph, "Post title"},
+	}
+
+	for i, el := range cases {
+		res := postTitle(el.feedTitle, el.ogTitle, el.policy)
+		if res != el.title {
+			t.Errorf("Expected title for case %d to be %q, but got %q", i, el.title, res)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package feeds
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+// OPML document
+// See: http://opml.org/spec2.opml
+type opmlDocument struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Head    opmlHead      `xml:"head"`
+	Outline []opmlOutline `xml:"body>outline"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type opmlOutline struct {
+	Text    string        `xml:"text,attr"`
+	Title   string        `xml:"title,attr,omitempty"`
+	Type    string        `xml:"type,attr,omitempty"`
+	XMLURL  string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL string        `xml:"htmlUrl,attr,omitempty"`
+	Outline []opmlOutline `xml:"outline"`
+}
+
+// ParseOPML reads an OPML document and returns the URLs of all feeds in it, including those in nested outlines
+// Duplicate URLs are returned only once
+func ParseOPML(r io.Reader) ([]string, error) {
+	doc := &opmlDocument{}
+	err := xml.NewDecoder(r).Decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0)
+	found := make(map[string]bool)
+	var walk func(outlines []opmlOutline)
+	walk = func(outlines []opmlOutline) {
+		for _, o := range outlines {
+			if o.XMLURL != "" && !found[o.XMLURL] {
+				found[o.XMLURL] = true
+				res = append(res, o.XMLURL)
+			}
+			walk(o.Outline)
+		}
+	}
+	walk(doc.Outline)
+
+	if len(res) == 0 {
+		return nil, errors.New("no feeds found in the OPML document")
+	}
+	return res, nil
+}
+
+// ExportOPML returns an OPML document with the list of feeds
+func ExportOPML(title string, feeds []models.Feed) ([]byte, error) {
+	doc := &opmlDocument{
+		Version: "2.0",
+		Head: opmlHead{
+			Title:       title,
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+		Outline: make([]opmlOutline, len(feeds)),
+	}
+	for i, feed := range feeds {
+		text := feed.Title
+		if text == "" {
+			text = feed.Url
+		}
+		doc.Outline[i] = opmlOutline{
+			Text:   text,
+			Title:  text,
+			Type:   "rss",
+			XMLURL: feed.Url,
+		}
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
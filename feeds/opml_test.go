@@ -0,0 +1,61 @@
+package feeds
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+func TestParseOPML(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.0">
+  <head><title>Subscriptions</title></head>
+  <body>
+    <outline text="Go blog" type="rss" xmlUrl="https://go.dev/blog/feed.atom"/>
+    <outline text="Folder">
+      <outline text="Nested" type="rss" xmlUrl="https://example.com/feed.xml"/>
+      <outline text="Duplicate" type="rss" xmlUrl="https://go.dev/blog/feed.atom"/>
+    </outline>
+    <outline text="Not a feed" htmlUrl="https://example.com"/>
+  </body>
+</opml>`
+
+	urls, err := ParseOPML(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("Error parsing OPML: %s", err)
+	}
+	expect := []string{"https://go.dev/blog/feed.atom", "https://example.com/feed.xml"}
+	if !reflect.DeepEqual(urls, expect) {
+		t.Fatalf("Expected %v, but got %v", expect, urls)
+	}
+
+	// Documents without feeds return an error
+	_, err = ParseOPML(strings.NewReader(`<opml version="2.0"><head/><body/></opml>`))
+	if err == nil {
+		t.Fatal("Expected an error for OPML without feeds")
+	}
+}
+
+func TestExportOPML(t *testing.T) {
+	feeds := []models.Feed{
+		{Url: "https://go.dev/blog/feed.atom", Title: "The Go Blog"},
+		{Url: "https://example.com/feed.xml"},
+	}
+	out, err := ExportOPML("Subscriptions", feeds)
+	if err != nil {
+		t.Fatalf("Error exporting OPML: %s", err)
+	}
+
+	// Parsing the result returns the same feeds
+	urls, err := ParseOPML(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("Error parsing exported OPML: %s", err)
+	}
+	expect := []string{"https://go.dev/blog/feed.atom", "https://example.com/feed.xml"}
+	if !reflect.DeepEqual(urls, expect) {
+		t.Fatalf("Expected %v, but got %v", expect, urls)
+	}
+}
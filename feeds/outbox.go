@@ -0,0 +1,262 @@
+package feeds
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+// Maximum number of delivery attempts for a message in the outbox
+const outboxMaxAttempts = 10
+
+// Delay before retrying to deliver a message for the first time; it doubles with each attempt
+const outboxRetryDelay = 30 * time.Second
+
+// Maximum delay between delivery attempts
+const outboxMaxRetryDelay = time.Hour
+
+// Delivered and failed messages are removed from the outbox after this time
+const outboxRetention = 7 * 24 * time.Hour
+
+// SetOutboxChan sets the channel used to notify the bot that there are new messages in the outbox
+func (f *Feeds) SetOutboxChan(ch chan<- struct{}) {
+	f.outboxCh = ch
+}
+
+// Notifies the bot that there are new messages in the outbox
+// This never blocks: if there's a notification pending already, that's enough
+func (f *Feeds) notifyOutbox() {
+	if f.outboxCh == nil {
+		return
+	}
+	select {
+	case f.outboxCh <- struct{}{}:
+	default:
+	}
+}
+
+// Adds a message to the outbox
+// The transaction is optional
+func (f *Feeds) queueMessage(chatId int64, feedId int64, post *Post, tx *sqlx.Tx) error {
+	// Use a transaction if we have one
+	var querier sqlx.Ext = db.GetDB()
+	if tx != nil {
+		querier = tx
+	}
+
+	now := time.Now().UTC()
+	_, err := querier.Exec("INSERT INTO outbox (chat_id, feed_id, post_title, post_link, post_date, post_photo, post_author, post_categories, post_summary, status, created_at, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", chatId, feedId, post.Title, post.Link, post.Date, post.Photo, post.Author, strings.Join(post.Categories, "\n"), post.Summary, models.OutboxStatusPending, now, now)
+	if err != nil {
+		f.log.Println("Error inserting in the database:", err)
+		return err
+	}
+	return nil
+}
+
+// Adds a text message to the outbox, such as a notification about the feed's status
+// The transaction is optional
+func (f *Feeds) queueText(chatId int64, feedId int64, message string, tx *sqlx.Tx) error {
+	// Use a transaction if we have one
+	var querier sqlx.Ext = db.GetDB()
+	if tx != nil {
+		querier = tx
+	}
+
+	now := time.Now().UTC()
+	_, err := querier.Exec("INSERT INTO outbox (chat_id, feed_id, post_title, post_link, post_date, post_photo, message, status, created_at, next_attempt_at) VALUES (?, ?, '', '', ?, '', ?, ?, ?, ?)", chatId, feedId, time.Time{}, message, models.OutboxStatusPending, now, now)
+	if err != nil {
+		f.log.Println("Error inserting in the database:", err)
+		return err
+	}
+	return nil
+}
+
+// QueuePhoto adds a message to the outbox with the photo of a post only, to be sent after the given time
+// This is used when the message for the post was too long to be the photo's caption, and the photo can't be sent right away
+func (f *Feeds) QueuePhoto(msg *UpdateMessage, after time.Time) error {
+	var feedId int64
+	// Note: the msg.Feed object might be nil
+	if msg.Feed != nil {
+		feedId = msg.Feed.ID
+	}
+
+	_, err := db.GetDB().Exec("INSERT INTO outbox (chat_id, feed_id, post_title, post_link, post_date, post_photo, photo_only, status, created_at, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?, 1, ?, ?, ?)", msg.ChatId, feedId, msg.Post.Title, msg.Post.Link, msg.Post.Date, msg.Post.Photo, models.OutboxStatusPending, time.Now().UTC(), after.UTC())
+	if err != nil {
+		f.log.Println("Error inserting in the database:", err)
+		return err
+	}
+	return nil
+}
+
+// PendingMessages returns up to limit messages from the outbox that are ready to be delivered, oldest first
+func (f *Feeds) PendingMessages(limit int) ([]UpdateMessage, error) {
+	DB := db.GetDB()
+
+	// Query the DB
+	rows := []models.OutboxMessage{}
+	err := DB.Select(&rows, "SELECT * FROM outbox WHERE status = ? AND next_attempt_at <= ? ORDER BY outbox_id ASC LIMIT ?", models.OutboxStatusPending, time.Now().UTC(), limit)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	// Load the feeds and the templates
+	// Feeds might have been deleted in the meanwhile, in which case the Feed property is nil
+	feeds := make(map[int64]*models.Feed)
+	settings := make(map[[2]int64]*messageSettings)
+	res := make([]UpdateMessage, len(rows))
+	for i, row := range rows {
+		feed, ok := feeds[row.FeedID]
+		if !ok {
+			feed, err = f.getFeedByID(row.FeedID)
+			if err != nil {
+				// Error was already logged
+				return nil, err
+			}
+			feeds[row.FeedID] = feed
+		}
+		ms, ok := settings[[2]int64{row.ChatID, row.FeedID}]
+		if !ok {
+			ms, err = f.messageSettings(row.ChatID, row.FeedID, DB)
+			if err != nil {
+				// Error was already logged
+				return nil, err
+			}
+			settings[[2]int64{row.ChatID, row.FeedID}] = ms
+		}
+
+		var categories []string
+		if row.PostCategories != "" {
+			categories = strings.Split(row.PostCategories, "\n")
+		}
+		photo := row.PostPhoto
+		if !ms.Images {
+			photo = ""
+		}
+
+		res[i] = UpdateMessage{
+			ID:       row.ID,
+			Attempts: row.Attempts,
+			Feed:     feed,
+			Post: Post{
+				Title:      row.PostTitle,
+				Link:       row.PostLink,
+				Date:       row.PostDate,
+				Photo:      photo,
+				Author:     row.PostAuthor,
+				Categories: categories,
+				Summary:    TruncateSummary(row.PostSummary, ms.SummaryLength),
+			},
+			Text:      row.Message,
+			PhotoOnly: row.PhotoOnly,
+			ChatId:    row.ChatID,
+			Template:  ms.Template,
+			Timezone:  ms.Timezone,
+		}
+	}
+
+	return res, nil
+}
+
+// Claim marks a message in the outbox as being sent, before sending it
+// It returns false if the message isn't pending anymore
+// Once claimed, the message is never sent again unless it's marked as failed, postponed, or released; so, if recording the result fails after sending it, the message isn't sent twice
+func (f *Feeds) Claim(msg *UpdateMessage) (bool, error) {
+	res, err := db.GetDB().Exec("UPDATE outbox SET status = ? WHERE outbox_id = ? AND status = ?", models.OutboxStatusSending, msg.ID, models.OutboxStatusPending)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Release returns a claimed message to the outbox without counting it as an attempt, updating its chat ID too
+// This is used when the message wasn't sent, such as when we're stopping or when the chat was migrated
+func (f *Feeds) Release(msg *UpdateMessage) error {
+	_, err := db.GetDB().Exec("UPDATE outbox SET status = ?, chat_id = ? WHERE outbox_id = ?", models.OutboxStatusPending, msg.ChatId, msg.ID)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	return nil
+}
+
+// MarkDelivered marks a message in the outbox as delivered
+func (f *Feeds) MarkDelivered(msg *UpdateMessage) error {
+	_, err := db.GetDB().Exec("UPDATE outbox SET status = ?, attempts = ? WHERE outbox_id = ?", models.OutboxStatusDelivered, msg.Attempts+1, msg.ID)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	return nil
+}
+
+// MarkFailed records a failed attempt at delivering a message in the outbox
+// The message is retried later with an exponential backoff, until the maximum number of attempts is reached
+func (f *Feeds) MarkFailed(msg *UpdateMessage, sendErr error) error {
+	attempts := msg.Attempts + 1
+
+	status := models.OutboxStatusPending
+	if attempts >= outboxMaxAttempts {
+		f.log.Printf("Giving up on delivering message %d to chat %d after %d attempts\n", msg.ID, msg.ChatId, attempts)
+		status = models.OutboxStatusFailed
+	}
+
+	// Compute the time of the next attempt
+	delay := outboxRetryDelay << (attempts - 1)
+	if delay > outboxMaxRetryDelay || delay <= 0 {
+		delay = outboxMaxRetryDelay
+	}
+	next := time.Now().UTC().Add(delay)
+
+	_, err := db.GetDB().Exec("UPDATE outbox SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ? WHERE outbox_id = ?", status, attempts, sendErr.Error(), next, msg.ID)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	return nil
+}
+
+// RemovePhoto removes the photo from a message in the outbox, such as when it can't be sent
+func (f *Feeds) RemovePhoto(msg *UpdateMessage) error {
+	_, err := db.GetDB().Exec("UPDATE outbox SET post_photo = '' WHERE outbox_id = ?", msg.ID)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	msg.Post.Photo = ""
+	return nil
+}
+
+// Postpone delays the delivery of a message in the outbox, without counting it as a failed attempt
+// Claimed messages are returned to the outbox
+func (f *Feeds) Postpone(msg *UpdateMessage, until time.Time) error {
+	_, err := db.GetDB().Exec("UPDATE outbox SET status = ?, next_attempt_at = ? WHERE outbox_id = ?", models.OutboxStatusPending, until.UTC(), msg.ID)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	return nil
+}
+
+// Removes delivered and failed messages from the outbox once they're past the retention period, as well as those whose result couldn't be recorded
+// This doesn't return errors but it only logs them
+func (f *Feeds) pruneOutbox() {
+	before := time.Now().UTC().Add(-outboxRetention)
+	_, err := db.GetDB().Exec("DELETE FROM outbox WHERE status != ? AND created_at < ?", models.OutboxStatusPending, before)
+	if err != nil {
+		f.log.Println("Error while pruning the outbox:", err)
+	}
+}
@@ -0,0 +1,84 @@
+package feeds
+
+import (
+	"errors"
+
+	"github.com/mmcdole/gofeed"
+
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+// Maximum number of posts that can be previewed
+const MaxPreviewPosts = 10
+
+// FeedPreview is the result of fetching a feed without subscribing to it
+type FeedPreview struct {
+	// The feed, which isn't stored in the database
+	// It includes the number of items that were skipped
+	Feed *models.Feed
+	// Number of valid posts in the feed
+	Count int
+	// Most recent posts, from old to new
+	Posts []Post
+}
+
+// PreviewFeed fetches a feed and returns its last n posts, without touching the database
+// If the URL is a web page that links to a single feed, that feed is previewed instead
+func (f *Feeds) PreviewFeed(url string, n int) (*FeedPreview, error) {
+	if n < 1 {
+		n = 1
+	} else if n > MaxPreviewPosts {
+		n = MaxPreviewPosts
+	}
+
+	feed := &models.Feed{
+		Url: url,
+	}
+	feed.Title = feed.Url
+	posts, err := f.RequestFeed(feed)
+	if errors.Is(err, gofeed.ErrFeedTypeNotDetected) {
+		// The URL could be a web page, so look for the feeds it links to
+		err = f.discoverPageFeeds(err)
+		var discoveryErr *FeedDiscoveryError
+		if errors.As(err, &discoveryErr) && len(discoveryErr.Candidates) == 1 {
+			feed.Url = discoveryErr.Candidates[0].Url
+			feed.Title = feed.Url
+			posts, err = f.RequestFeed(feed)
+		}
+	}
+	if err != nil {
+		f.log.Printf("Error while fetching feed %s for preview: %s\n", feed.Url, err)
+		return nil, err
+	}
+
+	res := &FeedPreview{
+		Feed: feed,
+	}
+	if feed.MovedTo != "" {
+		feed.Url = feed.MovedTo
+	}
+	if posts == nil {
+		return res, nil
+	}
+	if posts.Title != "" {
+		feed.Title = posts.Title
+	}
+	res.Count = len(posts.Items)
+
+	// Items are sorted from old to new
+	start := len(posts.Items) - n
+	if start < 0 {
+		start = 0
+	}
+	res.Posts = make([]Post, 0, len(posts.Items)-start)
+	for _, el := range posts.Items[start:] {
+		p := newPost(el)
+
+		// Request the metadata for the post, without using the cache
+		f.requestMetadata(&p, feed, false)
+
+		res.Posts = append(res.Posts, p)
+	}
+
+	return res, nil
+}
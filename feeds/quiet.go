@@ -0,0 +1,124 @@
+package feeds
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+// Error returned when the quiet hours are invalid
+var ErrInvalidQuietHours = errors.New("invalid quiet hours: use the format \"HH:MM-HH:MM\", for example \"23:00-07:00\"")
+
+// QuietHours is the time window, in the chat's time zone, in which messages don't notify the chat
+// The window can span midnight, such as 23:00-07:00
+type QuietHours struct {
+	StartHour   int
+	StartMinute int
+	EndHour     int
+	EndMinute   int
+}
+
+// ParseQuietHours parses quiet hours in the format "23:00-07:00"
+func ParseQuietHours(str string) (QuietHours, error) {
+	parts := strings.Split(strings.TrimSpace(str), "-")
+	if len(parts) != 2 {
+		return QuietHours{}, ErrInvalidQuietHours
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(parts[0]))
+	if err != nil {
+		return QuietHours{}, ErrInvalidQuietHours
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(parts[1]))
+	if err != nil {
+		return QuietHours{}, ErrInvalidQuietHours
+	}
+
+	q := QuietHours{
+		StartHour:   start.Hour(),
+		StartMinute: start.Minute(),
+		EndHour:     end.Hour(),
+		EndMinute:   end.Minute(),
+	}
+	if q.StartHour == q.EndHour && q.StartMinute == q.EndMinute {
+		return QuietHours{}, ErrInvalidQuietHours
+	}
+	return q, nil
+}
+
+// String returns the quiet hours in the format used in the database, which can be parsed with ParseQuietHours
+func (q QuietHours) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", q.StartHour, q.StartMinute, q.EndHour, q.EndMinute)
+}
+
+// End returns the time at which the quiet hours that include now end, in the given time zone
+// If now is not within the quiet hours, it returns the zero time
+func (q QuietHours) End(now time.Time, loc *time.Location) time.Time {
+	t := now.In(loc)
+	minute := t.Hour()*60 + t.Minute()
+	start := q.StartHour*60 + q.StartMinute
+	end := q.EndHour*60 + q.EndMinute
+
+	// Number of days from today at which the quiet hours end, or -1 if we're not within them
+	days := -1
+	switch {
+	case start < end && minute >= start && minute < end:
+		days = 0
+	case start > end && minute >= start:
+		// The window spans midnight and ends tomorrow
+		days = 1
+	case start > end && minute < end:
+		days = 0
+	}
+	if days < 0 {
+		return time.Time{}
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day()+days, q.EndHour, q.EndMinute, 0, 0, loc).UTC()
+}
+
+// SetChatQuietHours sets the quiet hours of a chat; pass nil to disable them
+func (f *Feeds) SetChatQuietHours(chatId int64, q *QuietHours) error {
+	value := ""
+	if q != nil {
+		value = q.String()
+	}
+	return f.setChatSetting(chatId, "chat_quiet_hours", value)
+}
+
+// SetChatQuietMode sets whether messages sent during quiet hours are held or delivered without a notification
+// The mode is one of the models.QuietMode* constants
+func (f *Feeds) SetChatQuietMode(chatId int64, mode string) error {
+	if mode != models.QuietModeHold && mode != models.QuietModeSilent {
+		return fmt.Errorf("invalid quiet mode: %s", mode)
+	}
+	return f.setChatSetting(chatId, "chat_quiet_mode", mode)
+}
+
+// QuietUntil returns the time at which the current quiet hours for the chat end, and what to do with messages until then
+// If the chat isn't in its quiet hours, it returns the zero time
+func (f *Feeds) QuietUntil(chatId int64, now time.Time) (until time.Time, mode string, err error) {
+	chat, err := f.GetChat(chatId)
+	if err != nil {
+		// Error was already logged
+		return time.Time{}, "", err
+	}
+	if chat.QuietHours == "" {
+		return time.Time{}, chat.QuietMode, nil
+	}
+
+	q, err := ParseQuietHours(chat.QuietHours)
+	if err != nil {
+		f.log.Printf("Invalid quiet hours '%s' for chat %d\n", chat.QuietHours, chatId)
+		return time.Time{}, chat.QuietMode, nil
+	}
+	loc, err := time.LoadLocation(chat.Timezone)
+	if err != nil {
+		f.log.Printf("Invalid time zone '%s' for chat %d\n", chat.Timezone, chatId)
+		loc = time.UTC
+	}
+
+	return q.End(now, loc), chat.QuietMode, nil
+}
@@ -0,0 +1,73 @@
+package feeds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+		err bool
+	}{
+		{"23:00-07:00", "23:00-07:00", false},
+		{"9:30-12:15", "09:30-12:15", false},
+		{" 22:00 - 06:30 ", "22:00-06:30", false},
+		{"", "", true},
+		{"23:00", "", true},
+		{"23:00-07:00-08:00", "", true},
+		{"25:00-07:00", "", true},
+		{"23:00-foo", "", true},
+		{"07:00-07:00", "", true},
+	}
+
+	for _, el := range cases {
+		q, err := ParseQuietHours(el.in)
+		if el.err {
+			if err == nil {
+				t.Fatalf("Expected an error for %s, but got %s", el.in, q)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Expected no error for %s, but got %s", el.in, err)
+		}
+		if q.String() != el.out {
+			t.Fatalf("Expected result for %s to be %s, but got %s", el.in, el.out, q)
+		}
+	}
+}
+
+func TestQuietHoursEnd(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	night, _ := ParseQuietHours("23:00-07:00")
+	day, _ := ParseQuietHours("12:00-14:30")
+	cases := []struct {
+		q   QuietHours
+		now time.Time
+		out time.Time
+	}{
+		{night, time.Date(2023, 3, 15, 22, 59, 0, 0, rome), time.Time{}},
+		{night, time.Date(2023, 3, 15, 23, 0, 0, 0, rome), time.Date(2023, 3, 16, 7, 0, 0, 0, rome)},
+		{night, time.Date(2023, 3, 16, 3, 0, 0, 0, rome), time.Date(2023, 3, 16, 7, 0, 0, 0, rome)},
+		{night, time.Date(2023, 3, 16, 7, 0, 0, 0, rome), time.Time{}},
+		{night, time.Date(2023, 3, 16, 12, 0, 0, 0, rome), time.Time{}},
+		// 02:00 UTC is 03:00 in Rome
+		{night, time.Date(2023, 3, 16, 2, 0, 0, 0, time.UTC), time.Date(2023, 3, 16, 7, 0, 0, 0, rome)},
+		{day, time.Date(2023, 3, 15, 11, 59, 0, 0, rome), time.Time{}},
+		{day, time.Date(2023, 3, 15, 13, 0, 0, 0, rome), time.Date(2023, 3, 15, 14, 30, 0, 0, rome)},
+		{day, time.Date(2023, 3, 15, 14, 30, 0, 0, rome), time.Time{}},
+	}
+
+	for i, el := range cases {
+		res := el.q.End(el.now, rome)
+		if !res.Equal(el.out) {
+			t.Fatalf("Expected result for case %d to be %s, but got %s", i, el.out, res)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package feeds
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+	"github.com/ItalyPaleAle/rss-bot/models"
+	"github.com/ItalyPaleAle/rss-bot/utils"
+)
+
+// Maximum number of redirects to follow
+const maxRedirects = 10
+
+// Sends a request, following redirects
+// If all redirects were permanent (301 or 308), it returns the final URL too; otherwise, that is empty
+func (f *Feeds) doRequestTrackRedirects(req *http.Request) (resp *http.Response, movedTo string, err error) {
+	permanent := true
+	location := ""
+
+	// Use a copy of the client with a custom redirect policy
+	client := *f.client
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return errors.New("stopped after 10 redirects")
+		}
+		// req.Response is the response that caused the redirect
+		if req.Response == nil || (req.Response.StatusCode != http.StatusMovedPermanently && req.Response.StatusCode != http.StatusPermanentRedirect) {
+			permanent = false
+		}
+		location = req.URL.String()
+		return nil
+	}
+
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	if permanent && location != "" {
+		movedTo = location
+	}
+	return resp, movedTo, nil
+}
+
+// Updates the URL of a feed that has moved permanently
+// If there's already a feed with the new URL, subscriptions are merged into that
+func (f *Feeds) moveFeed(feed *models.Feed, newUrl string) error {
+	DB := db.GetDB()
+
+	// Begin a transaction
+	tx, err := DB.Beginx()
+	if err != nil {
+		f.log.Println("Error starting a transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Check if the new URL is already in the database
+	target, err := f.GetFeedByURL(newUrl, tx)
+	if err != nil {
+		// Error was already logged
+		return err
+	}
+
+	if target == nil || target.ID == feed.ID {
+		// Just update the URL (the feed itself can match if only the scheme changed)
+		_, err = tx.Exec("UPDATE feeds SET feed_url = ?, feed_canonical_url = ? WHERE feed_id = ?", newUrl, utils.CanonicalURL(newUrl), feed.ID)
+		if err != nil {
+			f.log.Println("Error querying the database:", err)
+			return err
+		}
+		f.log.Printf("Feed %d moved from %s to %s\n", feed.ID, feed.Url, newUrl)
+	} else {
+		// Merge into the existing feed
+		err = f.mergeFeed(feed.ID, target.ID, tx)
+		if err != nil {
+			// Error was already logged
+			return err
+		}
+		f.log.Printf("Feed %d moved from %s to %s, and was merged into feed %d\n", feed.ID, feed.Url, newUrl, target.ID)
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		f.log.Println("Error while committing the transaction:", err)
+		return err
+	}
+
+	feed.Url = newUrl
+	feed.CanonicalUrl = utils.CanonicalURL(newUrl)
+	return nil
+}
@@ -3,7 +3,6 @@ package feeds
 import (
 	"errors"
 	"sort"
-	"strings"
 
 	"github.com/mmcdole/gofeed"
 
@@ -11,23 +10,22 @@ import (
 )
 
 // RequestFeed requests a feed of any kind
+// If the feed doesn't have a source set yet, this sets it as a side effect
 func (f *Feeds) RequestFeed(feed *models.Feed) (posts *gofeed.Feed, err error) {
 	if feed.Url == "" {
 		return nil, errors.New("empty feed URL")
 	}
 
-	// Check the type of feed
-	switch {
-	// Docker Hub
-	case strings.HasPrefix(feed.Url, "https://hub.docker.com/"):
-		posts, err = f.RequestDockerFeed(feed)
-	// Default: RSS feed
-	default:
-		posts, err = f.RequestRSSFeed(feed)
+	// Get the source for the feed
+	src, err := f.GetSource(feed)
+	if err != nil {
+		return nil, err
 	}
+	feed.Source = src.Name()
 
+	posts, err = src.Fetch(f, feed)
 	if err != nil {
-		return nil, err
+		return nil, classifyFetchError(err)
 	}
 
 	// Sort items by date, from old to new
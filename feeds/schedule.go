@@ -0,0 +1,149 @@
+package feeds
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+// Limits for the interval subscribers can set for fetching a feed
+const (
+	MinFetchInterval = time.Minute
+	MaxFetchInterval = 7 * 24 * time.Hour
+)
+
+// Returns the interval for fetching a feed
+func (f *Feeds) fetchInterval(interval int64) time.Duration {
+	if interval > 0 {
+		return time.Duration(interval) * time.Second
+	}
+	return f.defaultInterval
+}
+
+// Returns the time of the next fetch for a feed that is fetched with the given interval
+// A random jitter of ±10% is added to spread the load
+func nextFetchTime(interval time.Duration) time.Time {
+	jitter := time.Duration(rand.Int63n(int64(interval)/5+1)) - interval/10
+	return time.Now().UTC().Add(interval + jitter)
+}
+
+// Schedules the next fetch of a feed
+// Paused feeds are fetched only once a day
+func (f *Feeds) scheduleNextFetch(feed *models.Feed) error {
+	interval := f.fetchInterval(feed.Interval)
+	if feed.Paused {
+		interval = pausedFetchInterval
+	}
+	next := nextFetchTime(interval)
+	_, err := db.GetDB().Exec("UPDATE feeds SET feed_next_fetch_at = ? WHERE feed_id = ?", next, feed.ID)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	return nil
+}
+
+// SetSubscriptionInterval sets the interval for fetching a feed preferred by a subscriber
+// An interval of 0 resets it to the default
+func (f *Feeds) SetSubscriptionInterval(feedId int64, chatId int64, interval time.Duration) error {
+	DB := db.GetDB()
+
+	// Begin a transaction
+	tx, err := DB.Beginx()
+	if err != nil {
+		f.log.Println("Error starting a transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Update the subscription
+	subscriptionId, err := f.getSubscriptionID(feedId, chatId, tx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE subscriptions SET subscription_interval = ? WHERE subscription_id = ?", int64(interval/time.Second), subscriptionId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	// Update the interval of the feed
+	err = f.updateFeedInterval(feedId, tx)
+	if err != nil {
+		// Error was already logged
+		return err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		f.log.Println("Error while committing the transaction:", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetSubscriptionInterval returns the interval for fetching a feed preferred by a subscriber, and the one used for the feed
+// A subscription interval of 0 means the default one
+func (f *Feeds) GetSubscriptionInterval(feedId int64, chatId int64) (subscription time.Duration, feed time.Duration, err error) {
+	DB := db.GetDB()
+
+	res := &struct {
+		Subscription int64 `db:"subscription_interval"`
+		Feed         int64 `db:"feed_interval"`
+	}{}
+	err = DB.Get(res, "SELECT subscription_interval, feed_interval FROM subscriptions, feeds WHERE subscriptions.feed_id = ? AND chat_id = ? AND feeds.feed_id = subscriptions.feed_id", feedId, chatId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return 0, 0, err
+	}
+
+	return time.Duration(res.Subscription) * time.Second, f.fetchInterval(res.Feed), nil
+}
+
+// Updates the interval of a feed to the minimum across its subscribers, where those without a preference use the default
+// If the next fetch is scheduled later than the new interval allows, it's moved earlier
+func (f *Feeds) updateFeedInterval(feedId int64, tx *sqlx.Tx) error {
+	intervals := []int64{}
+	err := tx.Select(&intervals, "SELECT subscription_interval FROM subscriptions WHERE feed_id = ?", feedId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	if len(intervals) == 0 {
+		return nil
+	}
+
+	// Compute the minimum
+	// If all subscribers use the default, store 0 so changes to the default apply
+	var min int64
+	allDefault := true
+	for _, i := range intervals {
+		if i <= 0 {
+			i = int64(f.defaultInterval / time.Second)
+		} else {
+			allDefault = false
+		}
+		if min == 0 || i < min {
+			min = i
+		}
+	}
+	if allDefault {
+		min = 0
+	}
+
+	// Update the feed
+	next := time.Now().UTC().Add(f.fetchInterval(min))
+	_, err = tx.Exec("UPDATE feeds SET feed_interval = ?, feed_next_fetch_at = MIN(feed_next_fetch_at, ?) WHERE feed_id = ?", min, next, feedId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package feeds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mmcdole/gofeed"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+// Minimum number of seen items to keep for each feed
+// We always keep at least twice the number of items currently in the feed, so items still in the feed are never forgotten
+const seenItemsRetention = 500
+
+// Returns the key used to identify an item in the list of seen items
+// This is the item's GUID if present, otherwise its link, and as last resort a hash of title and link
+func itemKey(el *gofeed.Item) string {
+	if el.GUID != "" {
+		return el.GUID
+	}
+	if el.Link != "" {
+		return el.Link
+	}
+	h := sha256.Sum256([]byte(el.Title + "\n" + el.Link))
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+// Returns the set of keys of the items already seen for a feed
+func (f *Feeds) loadSeenItems(feedId int64) (map[string]bool, error) {
+	keys := []string{}
+	err := db.GetDB().Select(&keys, "SELECT item_key FROM seen_items WHERE feed_id = ?", feedId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return nil, err
+	}
+
+	res := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		res[k] = true
+	}
+	return res, nil
+}
+
+// Adds items to the list of seen items for a feed, then removes the oldest ones above the retention limit
+// The count argument is the number of items currently in the feed
+// The transaction is optional
+func (f *Feeds) markSeenItems(feedId int64, keys []string, count int, tx *sqlx.Tx) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// Use a transaction if we have one
+	var querier sqlx.Ext = db.GetDB()
+	if tx != nil {
+		querier = tx
+	}
+
+	// Add the items
+	// Items that are already present keep the time they were first seen
+	for _, k := range keys {
+		_, err := querier.Exec("INSERT OR IGNORE INTO seen_items (feed_id, item_key, seen_at) VALUES (?, ?, CURRENT_TIMESTAMP)", feedId, k)
+		if err != nil {
+			f.log.Println("Error inserting in the database:", err)
+			return err
+		}
+	}
+
+	// Remove the oldest items
+	limit := seenItemsRetention
+	if count*2 > limit {
+		limit = count * 2
+	}
+	_, err := querier.Exec("DELETE FROM seen_items WHERE feed_id = ? AND ROWID NOT IN (SELECT ROWID FROM seen_items WHERE feed_id = ? ORDER BY seen_at DESC, ROWID DESC LIMIT ?)", feedId, feedId, limit)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+
+	return nil
+}
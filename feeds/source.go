@@ -0,0 +1,95 @@
+package feeds
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+
+	"github.com/ItalyPaleAle/rss-bot/models"
+)
+
+// Source is a type of feed the bot can fetch, such as a RSS feed or the list of tags of an image on Docker Hub
+type Source interface {
+	// Name returns the identifier of the source, which is stored in the database
+	Name() string
+	// Description returns a human-readable description of the source
+	Description() string
+	// Match returns true if the source can fetch the feed at the URL
+	Match(url string) bool
+	// Fetch requests the feed and returns its items
+	// It can return a nil feed if there's nothing new
+	Fetch(f *Feeds, feed *models.Feed) (*gofeed.Feed, error)
+}
+
+// Name of the default source, used when no other source matches the URL
+const defaultSourceName = "rss"
+
+// RegisterSource adds a source to the registry
+// Sources are checked in the order they are registered; the RSS source is used as fallback when no source matches
+func (f *Feeds) RegisterSource(src Source) {
+	f.sources = append(f.sources, src)
+}
+
+// GetSource returns the source to use for the feed
+// If the feed has a source stored, that is used; otherwise, the source is picked by matching the URL
+func (f *Feeds) GetSource(feed *models.Feed) (Source, error) {
+	// If the source is already known, look it up by name
+	if feed.Source != "" {
+		if feed.Source == f.defaultSource.Name() {
+			return f.defaultSource, nil
+		}
+		for _, src := range f.sources {
+			if src.Name() == feed.Source {
+				return src, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown source '%s' for feed %d", feed.Source, feed.ID)
+	}
+
+	// Match the URL
+	for _, src := range f.sources {
+		if src.Match(feed.Url) {
+			return src, nil
+		}
+	}
+	return f.defaultSource, nil
+}
+
+// Source for RSS, Atom and JSON feeds
+type rssSource struct{}
+
+func (rssSource) Name() string {
+	return defaultSourceName
+}
+
+func (rssSource) Description() string {
+	return "RSS/Atom feed"
+}
+
+func (rssSource) Match(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+func (rssSource) Fetch(f *Feeds, feed *models.Feed) (*gofeed.Feed, error) {
+	return f.RequestRSSFeed(feed)
+}
+
+// Source for the tags of an image on Docker Hub
+type dockerSource struct{}
+
+func (dockerSource) Name() string {
+	return "docker"
+}
+
+func (dockerSource) Description() string {
+	return "Docker Hub image tags"
+}
+
+func (dockerSource) Match(url string) bool {
+	return strings.HasPrefix(url, "https://hub.docker.com/")
+}
+
+func (dockerSource) Fetch(f *Feeds, feed *models.Feed) (*gofeed.Feed, error) {
+	return f.RequestDockerFeed(feed)
+}
@@ -0,0 +1,309 @@
+package feeds
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// Default and maximum length of the summary included in messages, in characters
+// The maximum leaves room for the rest of the message within Telegram's limit of 4096 characters
+const (
+	DefaultSummaryLength = 300
+	MaxSummaryLength     = 2000
+)
+
+// HTML tags that are kept in summaries, and the Telegram tag they're converted to
+var summaryTags = map[string]string{
+	"b":          "b",
+	"strong":     "b",
+	"i":          "i",
+	"em":         "i",
+	"a":          "a",
+	"code":       "code",
+	"pre":        "pre",
+	"blockquote": "blockquote",
+}
+
+// HTML tags whose content is removed from summaries
+var summarySkipTags = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"head":     true,
+	"iframe":   true,
+	"noscript": true,
+	"svg":      true,
+	"template": true,
+}
+
+// HTML tags that start a new paragraph or line
+var summaryBlockTags = map[string]int{
+	"p":          2,
+	"div":        1,
+	"br":         1,
+	"tr":         1,
+	"h1":         2,
+	"h2":         2,
+	"h3":         2,
+	"h4":         2,
+	"h5":         2,
+	"h6":         2,
+	"ul":         1,
+	"ol":         1,
+	"table":      1,
+	"figure":     1,
+	"hr":         2,
+	"pre":        1,
+	"blockquote": 1,
+}
+
+// Returns the summary of a post from its description or content, as HTML that Telegram accepts
+func postSummary(description string, content string) string {
+	str := description
+	if strings.TrimSpace(str) == "" {
+		str = content
+	}
+	return TruncateSummary(sanitizeHTML(str), MaxSummaryLength)
+}
+
+// Writes HTML for Telegram, collapsing whitespace and the line breaks between paragraphs
+type summaryWriter struct {
+	b strings.Builder
+	// Line breaks and space to add before the next text
+	newlines int
+	space    bool
+	// Open tags
+	open []string
+}
+
+// Adds n line breaks before the next text
+func (w *summaryWriter) newline(n int) {
+	if w.b.Len() > 0 && n > w.newlines {
+		w.newlines = n
+	}
+}
+
+// Writes the pending line breaks or space
+func (w *summaryWriter) flush() {
+	if w.newlines > 0 {
+		w.b.WriteString(strings.Repeat("\n", w.newlines))
+	} else if w.space && w.b.Len() > 0 {
+		w.b.WriteByte(' ')
+	}
+	w.newlines = 0
+	w.space = false
+}
+
+// Writes text, escaping it
+// Unless the text is pre-formatted, whitespace is collapsed
+func (w *summaryWriter) text(str string, pre bool) {
+	if pre {
+		w.flush()
+		w.b.WriteString(escapeHTML(str))
+		return
+	}
+
+	words := strings.Fields(str)
+	if len(words) == 0 {
+		if str != "" {
+			w.space = true
+		}
+		return
+	}
+	if unicode.IsSpace([]rune(str)[0]) {
+		w.space = true
+	}
+	w.flush()
+	w.b.WriteString(escapeHTML(strings.Join(words, " ")))
+	if last := []rune(str); unicode.IsSpace(last[len(last)-1]) {
+		w.space = true
+	}
+}
+
+// Opens a tag
+func (w *summaryWriter) start(tag string, href string) {
+	w.flush()
+	if tag == "a" {
+		w.b.WriteString(`<a href="` + escapeHTML(href) + `">`)
+	} else {
+		w.b.WriteString("<" + tag + ">")
+	}
+	w.open = append(w.open, tag)
+}
+
+// Closes a tag, and all the tags that were opened after it
+func (w *summaryWriter) end(tag string) {
+	for i := len(w.open) - 1; i >= 0; i-- {
+		if w.open[i] != tag {
+			continue
+		}
+		for j := len(w.open) - 1; j >= i; j-- {
+			w.b.WriteString("</" + w.open[j] + ">")
+		}
+		w.open = w.open[:i]
+		return
+	}
+}
+
+// Returns true if the tag is open
+func (w *summaryWriter) isOpen(tag string) bool {
+	for _, t := range w.open {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Closes all tags and returns the result
+func (w *summaryWriter) String() string {
+	for i := len(w.open) - 1; i >= 0; i-- {
+		w.b.WriteString("</" + w.open[i] + ">")
+	}
+	w.open = nil
+	return strings.TrimSpace(w.b.String())
+}
+
+// Converts a HTML fragment to the subset of HTML that Telegram accepts
+// Supported tags are kept, while others are removed leaving their content; links are kept only if they're absolute http(s) URLs
+func sanitizeHTML(str string) string {
+	w := &summaryWriter{}
+	z := html.NewTokenizer(strings.NewReader(str))
+	skip := 0
+	// Number of tags that were not opened, so their end tags are ignored too
+	ignored := make(map[string]int)
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			// End of the document
+			return w.String()
+		case html.TextToken:
+			if skip == 0 {
+				w.text(string(z.Text()), w.isOpen("pre"))
+			}
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			tag := string(name)
+
+			// Ignore the content of some tags
+			if summarySkipTags[tag] {
+				if tt == html.StartTagToken {
+					skip++
+				} else if tt == html.EndTagToken && skip > 0 {
+					skip--
+				}
+				continue
+			}
+			if skip > 0 {
+				continue
+			}
+
+			// Line breaks
+			if n, ok := summaryBlockTags[tag]; ok {
+				w.newline(n)
+			}
+			if tag == "li" && tt != html.EndTagToken {
+				w.newline(1)
+				w.flush()
+				w.b.WriteString("• ")
+			}
+
+			// Supported tags
+			// Tags inside code blocks, and tags nested in the same tag (such as links in links), are not allowed
+			tgTag, ok := summaryTags[tag]
+			if !ok {
+				continue
+			}
+			switch tt {
+			case html.StartTagToken:
+				if w.isOpen(tgTag) || ((w.isOpen("pre") || w.isOpen("code")) && tag != "pre" && tag != "code") {
+					ignored[tgTag]++
+					continue
+				}
+				href := ""
+				if tgTag == "a" {
+					for hasAttr {
+						var key, val []byte
+						key, val, hasAttr = z.TagAttr()
+						if string(key) == "href" {
+							href = string(val)
+						}
+					}
+					u, err := url.Parse(strings.TrimSpace(href))
+					if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+						ignored[tgTag]++
+						continue
+					}
+					href = u.String()
+				}
+				w.start(tgTag, href)
+			case html.EndTagToken:
+				if ignored[tgTag] > 0 {
+					ignored[tgTag]--
+					continue
+				}
+				w.end(tgTag)
+			}
+		}
+	}
+}
+
+// TruncateSummary truncates a summary to at most n characters of text, keeping the HTML tags balanced
+// The summary must have been sanitized already
+func TruncateSummary(str string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	open := []string{}
+	count := 0
+	z := html.NewTokenizer(strings.NewReader(str))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			// End of the document
+			return b.String()
+		case html.TextToken:
+			text := []rune(string(z.Text()))
+			if count+len(text) <= n {
+				count += len(text)
+				b.Write(z.Raw())
+				continue
+			}
+
+			// Truncate, preferably at the end of a word if it's not too far back
+			cut := text[:n-count]
+			for i := len(cut) - 1; i > 0 && i >= len(cut)-20; i-- {
+				if unicode.IsSpace(cut[i]) {
+					cut = cut[:i]
+					break
+				}
+			}
+			b.WriteString(escapeHTML(strings.TrimRightFunc(string(cut), unicode.IsSpace)) + "…")
+			for i := len(open) - 1; i >= 0; i-- {
+				b.WriteString("</" + open[i] + ">")
+			}
+			return strings.TrimSpace(b.String())
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			open = append(open, string(name))
+			b.Write(z.Raw())
+		case html.EndTagToken:
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+			b.Write(z.Raw())
+		}
+	}
+}
+
+// Escapes the HTML entities as required by Telegram: <>&"
+func escapeHTML(str string) string {
+	r := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+	return r.Replace(str)
+}
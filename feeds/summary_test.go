@@ -0,0 +1,82 @@
+package feeds
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{``, ``},
+		{`Hello world`, `Hello world`},
+		{`<p>Hello <strong>world</strong></p><p>Second   paragraph</p>`, "Hello <b>world</b>\n\nSecond paragraph"},
+		{`Line 1<br>Line 2<br/>Line 3`, "Line 1\nLine 2\nLine 3"},
+		{`Tom &amp; Jerry &lt;3 "quoted"`, `Tom &amp; Jerry &lt;3 &quot;quoted&quot;`},
+		{`<script>alert(1)</script><style>p{}</style>Text`, `Text`},
+		{"\n\n<div>\n  Text\n</div>\n\n", `Text`},
+		{`<span class="x">Hi</span> <em>there</em>, <u>you</u>`, `Hi <i>there</i>, you`},
+		{`<img src="a.jpg"><h2>Title</h2>Text`, "Title\n\nText"},
+		{`<ul><li>One</li><li>Two</li></ul>`, "• One\n• Two"},
+		// Links
+		{`<a href="https://example.com/?a=1&amp;b=2">link</a>`, `<a href="https://example.com/?a=1&amp;b=2">link</a>`},
+		{`<a href="javascript:alert(1)">link</a>`, `link`},
+		{`<a href="/relative">link</a>`, `link`},
+		{`<a href="https://a.com">one <a href="https://b.com">two</a> three</a>`, `<a href="https://a.com">one two three</a>`},
+		// Nesting
+		{`<b>one <strong>two</strong> three</b>`, `<b>one two three</b>`},
+		{`<b>bold <i>both</b> none</i>`, `<b>bold <i>both</i></b> none`},
+		{`<b>not closed`, `<b>not closed</b>`},
+		{`stray</b> end`, `stray end`},
+		// Code
+		{"<pre><code>if (a &lt; b) {\n  <b>x</b>\n}</code></pre>", "<pre><code>if (a &lt; b) {\n  x\n}</code></pre>"},
+		{`<blockquote>Quote</blockquote>After`, "<blockquote>Quote</blockquote>\nAfter"},
+	}
+
+	for _, el := range cases {
+		res := sanitizeHTML(el.in)
+		if res != el.out {
+			t.Fatalf("Expected result for %q to be %q, but got %q", el.in, el.out, res)
+		}
+	}
+}
+
+func TestTruncateSummary(t *testing.T) {
+	cases := []struct {
+		in  string
+		n   int
+		out string
+	}{
+		{`Hello world`, 0, ``},
+		{`Hello world`, 11, `Hello world`},
+		{`Hello world`, 8, `Hello…`},
+		{`Hello <b>big</b> world`, 8, `Hello <b>bi…</b>`},
+		{`<a href="https://example.com">Hello world</a> again`, 9, `<a href="https://example.com">Hello…</a>`},
+		{`Tom &amp; Jerry`, 5, `Tom…`},
+		{`Tom &amp; Jerry`, 6, `Tom &amp;…`},
+		{`Hello <b>world</b>`, 6, `Hello <b>…</b>`},
+		{`Supercalifragilisticexpialidocious word`, 30, `Supercalifragilisticexpialidoc…`},
+	}
+
+	for _, el := range cases {
+		res := TruncateSummary(el.in, el.n)
+		if res != el.out {
+			t.Fatalf("Expected result for %q (%d) to be %q, but got %q", el.in, el.n, el.out, res)
+		}
+	}
+}
+
+func TestPostSummary(t *testing.T) {
+	if res := postSummary("", "<p>From content</p>"); res != "From content" {
+		t.Fatalf("Expected summary from the content, but got %q", res)
+	}
+	if res := postSummary("Description", "Content"); res != "Description" {
+		t.Fatalf("Expected summary from the description, but got %q", res)
+	}
+	res := postSummary(strings.Repeat("è ", MaxSummaryLength), "")
+	if len([]rune(res)) > MaxSummaryLength+1 || !strings.HasSuffix(res, "…") {
+		t.Fatalf("Expected summary to be truncated, but got %d characters", len([]rune(res)))
+	}
+}
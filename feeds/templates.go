@@ -0,0 +1,73 @@
+package feeds
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+// SetChatTemplate sets the template for messages sent to a chat; pass an empty string to use the default one
+// The template must have been validated already
+func (f *Feeds) SetChatTemplate(chatId int64, tpl string) error {
+	return f.setChatSetting(chatId, "chat_template", tpl)
+}
+
+// SetSubscriptionTemplate sets the template for messages of a subscription; pass an empty string to use the chat's one
+// The template must have been validated already
+func (f *Feeds) SetSubscriptionTemplate(feedId int64, chatId int64, tpl string) error {
+	subscriptionId, err := f.getSubscriptionID(feedId, chatId, db.GetDB())
+	if err != nil {
+		return err
+	}
+
+	_, err = db.GetDB().Exec("UPDATE subscriptions SET subscription_template = ? WHERE subscription_id = ?", tpl, subscriptionId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return err
+	}
+	return nil
+}
+
+// GetSubscriptionTemplate returns the template for messages of a subscription, which is empty if it uses the chat's one
+func (f *Feeds) GetSubscriptionTemplate(feedId int64, chatId int64) (string, error) {
+	tpl := ""
+	err := db.GetDB().Get(&tpl, "SELECT subscription_template FROM subscriptions WHERE feed_id = ? AND chat_id = ?", feedId, chatId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return "", err
+	}
+	return tpl, nil
+}
+
+// SetChatSummaryLength sets the maximum length of the summary of posts included in messages sent to a chat; 0 disables summaries
+func (f *Feeds) SetChatSummaryLength(chatId int64, length int) error {
+	if length < 0 || length > MaxSummaryLength {
+		return fmt.Errorf("invalid summary length: %d", length)
+	}
+	return f.setChatSetting(chatId, "chat_summary_length", length)
+}
+
+// Settings used to format the messages of a feed sent to a chat
+type messageSettings struct {
+	// Template for the messages: the subscription's, then the chat's; empty to use the default one
+	Template string `db:"template"`
+	// Time zone of the chat
+	Timezone string `db:"timezone"`
+	// Maximum length of the summary of posts; 0 if summaries are disabled
+	SummaryLength int `db:"summary_length"`
+	// If false, images of posts are not sent
+	Images bool `db:"images"`
+}
+
+// Returns the settings used to format the messages of a feed sent to a chat
+func (f *Feeds) messageSettings(chatId int64, feedId int64, querier sqlx.Queryer) (*messageSettings, error) {
+	res := &messageSettings{}
+	err := sqlx.Get(querier, res, "SELECT COALESCE((SELECT subscription_template FROM subscriptions WHERE chat_id = ? AND feed_id = ? AND subscription_template != ''), (SELECT chat_template FROM chats WHERE chat_id = ?), '') AS template, COALESCE((SELECT chat_timezone FROM chats WHERE chat_id = ?), 'UTC') AS timezone, COALESCE((SELECT chat_summary_length FROM chats WHERE chat_id = ?), 0) AS summary_length, COALESCE((SELECT chat_images FROM chats WHERE chat_id = ?), 1) AS images", chatId, feedId, chatId, chatId, chatId, chatId)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return nil, err
+	}
+	return res, nil
+}
@@ -1,6 +1,10 @@
 package feeds
 
 import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
 	"github.com/ItalyPaleAle/rss-bot/db"
 	"github.com/ItalyPaleAle/rss-bot/models"
 )
@@ -8,11 +12,6 @@ import (
 // Number of parallel requests to make
 const parallelFetch = 4
 
-// SetUpdateChan sets the channel to use for notify the bot of new messages for subscribers
-func (f *Feeds) SetUpdateChan(ch chan<- UpdateMessage) {
-	f.updateCh = ch
-}
-
 // QueueUpdate queues an update of the feeds
 func (f *Feeds) QueueUpdate() {
 	// The channel has a capacity of 1, which means that there can only be 1 running and one queued
@@ -43,8 +42,21 @@ func (f *Feeds) QueueUpdate() {
 }
 
 type workerResult struct {
-	Feed  *models.Feed
+	Feed *models.Feed
+	Err  error
+	fetchResult
+}
+
+// Result of fetching a feed
+type fetchResult struct {
+	// New posts
 	Posts []Post
+	// New posts as they are in the feed, before requesting their metadata; filters are matched against these
+	Items []Post
+	// Keys of the items in the feed that were not in the list of seen items
+	Unseen []string
+	// Number of items in the feed
+	Count int
 }
 
 // Internal worker that fetches and processes feeds, in parallel
@@ -55,45 +67,62 @@ func (f *Feeds) updateWorker(id int, jobs <-chan *models.Feed, results chan<- wo
 		}
 		f.log.Println("Worker", id, "started updating feed", j.ID)
 		// Fetch new data from the feed
-		posts, err := f.fetchFeed(j)
+		fetched, err := f.fetchFeed(j)
 		if err != nil {
 			// Error is already logged
 			// Just move to the next post
+			res.Err = err
 			results <- res
 			continue
 		}
-		res.Posts = posts
+		res.fetchResult = fetched
 		f.log.Println("Worker", id, "finished updating feed", j.ID)
 		results <- res
 	}
 }
 
-// Worker that updates all feeds
+// Worker that updates all feeds that are due
 func (f *Feeds) updateFeeds() error {
-	f.log.Println("Started updating feeds")
+	// Select all feeds that are due, and return if there's none
+	due := 0
+	err := db.GetDB().Get(&due, "SELECT COUNT(*) FROM feeds WHERE feed_next_fetch_at <= ?", time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if due == 0 {
+		return nil
+	}
+
+	f.log.Printf("Started updating %d feeds\n", due)
 
 	// Start background workers to parallelize requests
-	// Channels' buffer is 4x the number of workers
+	// The jobs channel's buffer is 4x the number of workers, while the results channel can hold all results so workers never block while we're still queueing jobs
 	jobs := make(chan *models.Feed, (parallelFetch * 4))
-	results := make(chan workerResult, (parallelFetch * 4))
+	results := make(chan workerResult, due)
 	for i := 1; i <= parallelFetch; i++ {
 		go f.updateWorker(i, jobs, results)
 	}
 
-	// Select all feeds
+	// In case of errors, stop queueing jobs and wait for the workers to finish the ones that were queued already
+	// The results channel isn't closed here, as workers could still be sending to it
 	count := 0
-	rows, err := db.GetDB().Queryx("SELECT * FROM feeds")
-	if err != nil {
-		rows.Close()
+	abort := func(err error) error {
+		close(jobs)
+		for i := 0; i < count; i++ {
+			<-results
+		}
 		return err
 	}
+
+	rows, err := db.GetDB().Queryx("SELECT * FROM feeds WHERE feed_next_fetch_at <= ? ORDER BY feed_next_fetch_at ASC LIMIT ?", time.Now().UTC(), due)
+	if err != nil {
+		return abort(err)
+	}
 	for rows.Next() {
 		// If the context was canceled, return
 		if err := f.ctx.Err(); err != nil {
 			rows.Close()
-			close(jobs)
-			close(results)
-			return err
+			return abort(err)
 		}
 
 		// Read the row
@@ -101,20 +130,17 @@ func (f *Feeds) updateFeeds() error {
 		err = rows.StructScan(&feed)
 		if err != nil {
 			rows.Close()
-			close(jobs)
-			close(results)
-			return err
+			return abort(err)
 		}
 
 		// Get a worker to perform the request
 		jobs <- &feed
 		count++
 	}
+	err = rows.Err()
 	rows.Close()
 	if err != nil {
-		close(jobs)
-		close(results)
-		return err
+		return abort(err)
 	}
 
 	// Close the jobs channel
@@ -124,60 +150,104 @@ func (f *Feeds) updateFeeds() error {
 	for i := 0; i < count; i++ {
 		res := <-results
 
-		// If there are new posts…
-		if len(res.Posts) > 0 {
-			// …first, update the feed object in the database
-			f.setLastPost(res.Feed)
+		// Update the health status of the feed, then schedule the next fetch
+		// Ignore errors (already logged)
+		_ = f.recordFetchResult(res.Feed, res.Err)
+		_ = f.scheduleNextFetch(res.Feed)
+
+		// Save the new state of the feed and queue messages for subscribers
+		// Ignore errors (already logged)
+		_ = f.saveFetchResult(&res)
 
-			// …second, notify subscribers
+		// If the feed has moved permanently, update its URL
+		if res.Err == nil && res.Feed.MovedTo != "" {
 			// Ignore errors (already logged)
-			_ = f.notifySubscribers(res.Feed, res.Posts)
+			_ = f.moveFeed(res.Feed, res.Feed.MovedTo)
 		}
 	}
 	close(results)
 
+	// Remove old messages from the outbox, and expired images and link metadata from the caches
+	f.pruneOutbox()
+	f.pruneImageCache()
+	f.pruneLinkMetadata()
+
 	f.log.Println("Done updating feeds")
 
 	return nil
 }
 
 // Fetches a feed and return the new posts only
+// Posts are new if they are not in the list of seen items for the feed
 // If there are new posts, the feed object is updated too as a side effect
-func (f *Feeds) fetchFeed(feed *models.Feed) ([]Post, error) {
+func (f *Feeds) fetchFeed(feed *models.Feed) (res fetchResult, err error) {
 	// Request the data
 	f.log.Printf("Updating feed %d (%s)\n", feed.ID, feed.Url)
 	posts, err := f.RequestFeed(feed)
 	if err != nil {
 		f.log.Printf("Error while fetching feed %d: %s\n", feed.ID, err)
-		return nil, err
+		return res, err
+	}
+
+	// Get the list of items we've seen already
+	seen, err := f.loadSeenItems(feed.ID)
+	if err != nil {
+		// Error was already logged
+		return res, err
+	}
+
+	// Get the subscriptions to the feed and their filters, so we don't request the web page of posts that no one would receive
+	subs := []int64{}
+	err = db.GetDB().Select(&subs, "SELECT subscription_id FROM subscriptions WHERE feed_id = ?", feed.ID)
+	if err != nil {
+		f.log.Println("Error querying the database:", err)
+		return res, err
+	}
+	filters, err := f.loadFeedFilters(feed.ID, db.GetDB())
+	if err != nil {
+		// Error was already logged
+		return res, err
 	}
 
 	// Get all new entries
-	res := make([]Post, 0)
+	res.Posts = make([]Post, 0)
 	if posts != nil && len(posts.Items) > 0 {
-		after := feed.LastPostDate
+		res.Count = len(posts.Items)
 		for _, el := range posts.Items {
+			if el == nil || el.PublishedParsed == nil {
+				continue
+			}
+
 			// Check if this is a new post
-			if el != nil && el.PublishedParsed != nil && el.PublishedParsed.After(after) {
-				p := Post{
-					Title: el.Title,
-					Link:  el.Link,
-					Date:  *el.PublishedParsed,
-				}
-
-				// Request the metadata for the post
-				f.RequestMetadata(&p)
-
-				// Add it to the result
-				res = append(res, p)
-
-				// Look for the most recent post for updating the feed object
-				if el.PublishedParsed.After(feed.LastPostDate) {
-					feed.LastPostTitle = p.Title
-					feed.LastPostLink = p.Link
-					feed.LastPostDate = p.Date
-					feed.LastPostPhoto = p.Photo
-				}
+			key := itemKey(el)
+			if seen[key] {
+				continue
+			}
+			res.Unseen = append(res.Unseen, key)
+
+			// Feeds that have never been seen before (such as those added before we started tracking seen items) fall back to comparing the date with the last post's
+			if len(seen) == 0 && !el.PublishedParsed.After(feed.LastPostDate) {
+				continue
+			}
+
+			p := newPost(el)
+			item := p
+
+			// Request the metadata for the post, unless it doesn't pass the filters of any subscription
+			if anySubscriptionMatches(subs, filters, &item) {
+				f.RequestMetadata(&p, feed)
+			}
+
+			// Add it to the result
+			res.Posts = append(res.Posts, p)
+			res.Items = append(res.Items, item)
+
+			// Look for the most recent post for updating the feed object
+			if el.PublishedParsed.After(feed.LastPostDate) {
+				feed.LastPostTitle = p.Title
+				feed.LastPostLink = p.Link
+				feed.LastPostDate = p.Date
+				feed.LastPostPhoto = p.Photo
 			}
 		}
 	}
@@ -191,50 +261,119 @@ func (f *Feeds) fetchFeed(feed *models.Feed) ([]Post, error) {
 	return res, nil
 }
 
+// Saves the result of fetching a feed in the database
+// In the same transaction, this updates the feed, adds items to the list of seen ones, and queues messages for the subscribers in the outbox
+func (f *Feeds) saveFetchResult(res *workerResult) error {
+	if len(res.Posts) == 0 && len(res.Unseen) == 0 {
+		return nil
+	}
+
+	// Begin a transaction
+	tx, err := db.GetDB().Beginx()
+	if err != nil {
+		f.log.Println("Error starting a transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// If there are new posts…
+	if len(res.Posts) > 0 {
+		// …first, update the feed object in the database
+		err = f.setLastPost(res.Feed, tx)
+		if err != nil {
+			// Error was already logged
+			return err
+		}
+	}
+
+	// …second, add the items to the list of seen ones
+	err = f.markSeenItems(res.Feed.ID, res.Unseen, res.Count, tx)
+	if err != nil {
+		// Error was already logged
+		return err
+	}
+
+	// …third, notify subscribers
+	if len(res.Posts) > 0 {
+		err = f.notifySubscribers(res.Feed, res.Posts, res.Items, tx)
+		if err != nil {
+			// Error was already logged
+			return err
+		}
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		f.log.Println("Error while committing the transaction:", err)
+		return err
+	}
+
+	// Wake up the bot to deliver the messages
+	if len(res.Posts) > 0 {
+		f.notifyOutbox()
+	}
+
+	return nil
+}
+
 // Update a feed in the database, setting the new details for the last post
-// This doesn't return errors but it only logs them
-func (f *Feeds) setLastPost(feed *models.Feed) {
+func (f *Feeds) setLastPost(feed *models.Feed, tx *sqlx.Tx) error {
 	f.log.Printf("Updating last post for feed %d\n", feed.ID)
 
-	// Note that we're not using a transaction here (because the update process can take a while), but there's only one of these methods that can be running at the same time
-	// The bot can be deleting the feed in the meanwhile, but this would just make the next query fail (and that's why we're ignoring the error here)
-	_, err := db.GetDB().Exec("UPDATE feeds SET feed_title = ?, feed_last_modified = ?, feed_etag = ?, feed_last_post_title = ?, feed_last_post_link = ?, feed_last_post_date = ?, feed_last_post_photo = ? WHERE feed_id = ?", feed.Title, feed.LastModified, feed.ETag, feed.LastPostTitle, feed.LastPostLink, feed.LastPostDate, feed.LastPostPhoto, feed.ID)
+	// The bot can be deleting the feed in the meanwhile, but this would just make the query update no row
+	_, err := tx.Exec("UPDATE feeds SET feed_title = ?, feed_last_modified = ?, feed_etag = ?, feed_last_post_title = ?, feed_last_post_link = ?, feed_last_post_date = ?, feed_last_post_photo = ? WHERE feed_id = ?", feed.Title, feed.LastModified, feed.ETag, feed.LastPostTitle, feed.LastPostLink, feed.LastPostDate, feed.LastPostPhoto, feed.ID)
 	if err != nil {
-		f.log.Printf("Error while updating the last post for feed %s (id: %d), but continuing with next. Error: %s\n", feed.Url, feed.ID, err)
+		f.log.Printf("Error while updating the last post for feed %s (id: %d). Error: %s\n", feed.Url, feed.ID, err)
+		return err
 	}
+	return nil
 }
 
-// Sends a notification to all subscribers when a new post is out
-func (f *Feeds) notifySubscribers(feed *models.Feed, posts []Post) error {
+// Queues a message in the outbox for all subscribers when a new post is out
+// Filters are matched against items, which contains the posts as they are in the feed
+func (f *Feeds) notifySubscribers(feed *models.Feed, posts []Post, items []Post, tx *sqlx.Tx) error {
 	// Get the list of subscribers for this feed
-	sub := &models.Subscription{}
-	rows, err := db.GetDB().Queryx("SELECT chat_id FROM subscriptions WHERE feed_id = ?", feed.ID)
-	defer rows.Close()
+	subs := []models.Subscription{}
+	err := tx.Select(&subs, "SELECT * FROM subscriptions WHERE feed_id = ?", feed.ID)
 	if err != nil {
 		f.log.Println("Error querying the database:", err)
 		return err
 	}
-	subCount := 0
-	for rows.Next() {
-		// Read the row
-		err = rows.StructScan(&sub)
-		if err != nil {
-			f.log.Println("Error reading a row:", err)
-			return err
-		}
 
-		// Send the message to the channel
-		for _, post := range posts {
-			f.updateCh <- UpdateMessage{
-				Feed:   feed,
-				Post:   post,
-				ChatId: sub.ChatID,
+	// Get the filters for the subscriptions
+	filters, err := f.loadFeedFilters(feed.ID, tx)
+	if err != nil {
+		// Error was already logged
+		return err
+	}
+
+	count := 0
+	digest := 0
+	for _, sub := range subs {
+		isDigest := sub.Delivery != "" && sub.Delivery != models.DeliveryInstant
+		for i := range posts {
+			// Skip posts that don't pass the subscription's filters
+			if len(filters[sub.ID]) > 0 && !matchFilters(filters[sub.ID], &items[i]) {
+				continue
+			}
+
+			// Add the post to the digest, or the message to the outbox
+			if isDigest {
+				err = f.queueDigestItem(sub.ID, &posts[i], tx)
+				digest++
+			} else {
+				err = f.queueMessage(sub.ChatID, feed.ID, &posts[i], tx)
+				count++
+			}
+			if err != nil {
+				// Error was already logged
+				return err
 			}
 		}
-		subCount++
 	}
 
-	f.log.Printf("Found %d new posts in feed id %d, and notified %d subscribers\n", len(posts), feed.ID, subCount)
+	f.log.Printf("Found %d new posts in feed id %d, and queued %d messages and %d digest items for %d subscribers\n", len(posts), feed.ID, count, digest, len(subs))
 
 	return nil
 }
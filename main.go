@@ -2,6 +2,11 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	// Embed the time zone database, used for the time zone of chats, in case it's not available on the system
+	_ "time/tzdata"
 
 	"github.com/spf13/viper"
 
@@ -26,7 +31,15 @@ func main() {
 		panic(err)
 	}
 
-	// Start the bot - this is a blocking call
+	// Stop the bot when the process is interrupted or terminated
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		b.Stop()
+	}()
+
+	// Start the bot - this is a blocking call that returns when the bot is stopped
 	err = b.Start()
 	if err != nil {
 		panic(err)
@@ -39,7 +52,17 @@ func loadConfig() {
 	viper.SetDefault("TelegramAPIDebug", false)
 	viper.SetDefault("DBPath", "./bot.db")
 	viper.SetDefault("FeedUpdateInterval", 600)
+	viper.SetDefault("FeedFailureNotifyAfter", 86400)
+	viper.SetDefault("FeedPauseAfter", 1209600)
+	viper.SetDefault("MaxImageSize", 20971520)
+	viper.SetDefault("ImageCacheDir", "")
+	viper.SetDefault("MaxPageSize", 1048576)
 	viper.SetDefault("AllowedUsers", nil)
+	viper.SetDefault("WebhookURL", "")
+	viper.SetDefault("WebhookListen", "")
+	viper.SetDefault("WebhookSecretToken", "")
+	viper.SetDefault("WebhookTLSCert", "")
+	viper.SetDefault("WebhookTLSKey", "")
 
 	// Env
 	viper.SetEnvPrefix("BOT")
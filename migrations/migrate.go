@@ -19,4 +19,72 @@ func Migrate() {
 	if err != nil {
 		panic(fmt.Sprintln("Error migrating the database to V3", err))
 	}
+	err = V4()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V4", err))
+	}
+	err = V5()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V5", err))
+	}
+	err = V6()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V6", err))
+	}
+	err = V7()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V7", err))
+	}
+	err = V8()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V8", err))
+	}
+	err = V9()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V9", err))
+	}
+	err = V10()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V10", err))
+	}
+	err = V11()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V11", err))
+	}
+	err = V12()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V12", err))
+	}
+	err = V13()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V13", err))
+	}
+	err = V14()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V14", err))
+	}
+	err = V15()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V15", err))
+	}
+	err = V16()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V16", err))
+	}
+	err = V17()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V17", err))
+	}
+	err = V18()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V18", err))
+	}
+	err = V19()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V19", err))
+	}
+	err = V20()
+	if err != nil {
+		panic(fmt.Sprintln("Error migrating the database to V20", err))
+	}
 }
@@ -0,0 +1,172 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/viper"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+	"github.com/ItalyPaleAle/rss-bot/utils"
+)
+
+func V10() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 10 if needed
+	if version < 10 {
+		fmt.Println("Migrating database to version 10")
+
+		// This migration adds the canonical URL of feeds, which is used to find duplicates, merging feeds that are duplicates already
+		// The URL that is requested is not changed
+		tx, err := DB.Beginx()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec(`
+ALTER TABLE feeds ADD COLUMN feed_canonical_url text not null default '';
+CREATE INDEX IF NOT EXISTS feeds_feed_canonical_url ON feeds (feed_canonical_url);
+`)
+		if err != nil {
+			return err
+		}
+
+		feeds := []struct {
+			ID        int64  `db:"feed_id"`
+			Url       string `db:"feed_url"`
+			Canonical string
+		}{}
+		err = tx.Select(&feeds, "SELECT feed_id, feed_url FROM feeds ORDER BY feed_id ASC")
+		if err != nil {
+			return err
+		}
+
+		// Group feeds by their canonical URL, ignoring the scheme
+		// In each group, the feed that is kept is the first one using https, or the oldest one
+		groups := map[string][]int{}
+		keys := []string{}
+		for i, feed := range feeds {
+			canonical := utils.CanonicalURL(feed.Url)
+			key := strings.TrimPrefix(strings.TrimPrefix(canonical, "https://"), "http://")
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], i)
+			feeds[i].Canonical = canonical
+		}
+
+		for _, key := range keys {
+			group := groups[key]
+			target := group[0]
+			for _, i := range group {
+				if strings.HasPrefix(feeds[i].Canonical, "https://") {
+					target = i
+					break
+				}
+			}
+
+			// Merge the duplicates into the target
+			for _, i := range group {
+				if i == target {
+					continue
+				}
+				fmt.Printf("Merging feed %d into feed %d (%s)\n", feeds[i].ID, feeds[target].ID, feeds[target].Url)
+				err = v10MergeFeed(tx, feeds[i].ID, feeds[target].ID)
+				if err != nil {
+					return err
+				}
+			}
+			if len(group) > 1 {
+				err = v10UpdateInterval(tx, feeds[target].ID)
+				if err != nil {
+					return err
+				}
+			}
+
+			// Store the canonical URL
+			_, err = tx.Exec("UPDATE feeds SET feed_canonical_url = ? WHERE feed_id = ?", feeds[target].Canonical, feeds[target].ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec("UPDATE migrations SET version = 10 WHERE ROWID = 0")
+		if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merges a feed into another one, moving subscriptions, messages and seen items
+func v10MergeFeed(tx *sqlx.Tx, fromId int64, toId int64) error {
+	queries := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM outbox WHERE feed_id = ? AND status = ? AND chat_id IN (SELECT chat_id FROM subscriptions WHERE feed_id = ?)", []interface{}{fromId, "pending", toId}},
+		{"DELETE FROM subscriptions WHERE feed_id = ? AND chat_id IN (SELECT chat_id FROM subscriptions WHERE feed_id = ?)", []interface{}{fromId, toId}},
+		{"DELETE FROM filters WHERE subscription_id NOT IN (SELECT subscription_id FROM subscriptions)", nil},
+		{"UPDATE subscriptions SET feed_id = ? WHERE feed_id = ?", []interface{}{toId, fromId}},
+		{"UPDATE outbox SET feed_id = ? WHERE feed_id = ?", []interface{}{toId, fromId}},
+		{"INSERT OR IGNORE INTO seen_items (feed_id, item_key, seen_at) SELECT ?, item_key, seen_at FROM seen_items WHERE feed_id = ?", []interface{}{toId, fromId}},
+		{"DELETE FROM seen_items WHERE feed_id = ?", []interface{}{fromId}},
+		{"UPDATE feeds SET feed_next_fetch_at = MIN(feed_next_fetch_at, (SELECT feed_next_fetch_at FROM feeds WHERE feed_id = ?)) WHERE feed_id = ?", []interface{}{fromId, toId}},
+		{"DELETE FROM feeds WHERE feed_id = ?", []interface{}{fromId}},
+	}
+	for _, q := range queries {
+		_, err := tx.Exec(q.query, q.args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Updates the interval of a feed to the minimum across its subscribers
+func v10UpdateInterval(tx *sqlx.Tx, feedId int64) error {
+	intervals := []int64{}
+	err := tx.Select(&intervals, "SELECT subscription_interval FROM subscriptions WHERE feed_id = ?", feedId)
+	if err != nil {
+		return err
+	}
+
+	// Subscribers without a preference use the default; if all of them do, store 0
+	var min int64
+	allDefault := true
+	for _, i := range intervals {
+		if i <= 0 {
+			i = viper.GetInt64("FeedUpdateInterval")
+		} else {
+			allDefault = false
+		}
+		if min == 0 || i < min {
+			min = i
+		}
+	}
+	if allDefault {
+		min = 0
+	}
+
+	_, err = tx.Exec("UPDATE feeds SET feed_interval = ? WHERE feed_id = ?", min, feedId)
+	return err
+}
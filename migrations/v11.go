@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+func V11() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 11 if needed
+	if version < 11 {
+		fmt.Println("Migrating database to version 11")
+		sqlStmt := `
+CREATE TABLE IF NOT EXISTS chats (
+	chat_id integer primary key,
+	chat_backfill integer not null default 1
+);
+UPDATE migrations SET version = 11 WHERE ROWID = 0;
+`
+
+		_, err := DB.Exec(sqlStmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+func V12() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 12 if needed
+	if version < 12 {
+		fmt.Println("Migrating database to version 12")
+		sqlStmt := `
+ALTER TABLE subscriptions ADD COLUMN subscription_delivery text not null default 'instant';
+ALTER TABLE subscriptions ADD COLUMN subscription_next_digest_at timestamp not null default '1970-01-01 00:00:00';
+ALTER TABLE chats ADD COLUMN chat_timezone text not null default 'UTC';
+CREATE TABLE IF NOT EXISTS digest_items (
+	digest_item_id integer primary key autoincrement,
+	subscription_id integer not null,
+	post_title text not null,
+	post_link text not null,
+	post_date timestamp not null,
+	created_at timestamp not null
+);
+CREATE INDEX IF NOT EXISTS digest_items_subscription_id ON digest_items (subscription_id);
+UPDATE migrations SET version = 12 WHERE ROWID = 0;
+`
+
+		_, err := DB.Exec(sqlStmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
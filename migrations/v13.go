@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+func V13() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 13 if needed
+	if version < 13 {
+		fmt.Println("Migrating database to version 13")
+		sqlStmt := `
+ALTER TABLE chats ADD COLUMN chat_quiet_hours text not null default '';
+ALTER TABLE chats ADD COLUMN chat_quiet_mode text not null default 'hold';
+UPDATE migrations SET version = 13 WHERE ROWID = 0;
+`
+
+		_, err := DB.Exec(sqlStmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+func V14() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 14 if needed
+	if version < 14 {
+		fmt.Println("Migrating database to version 14")
+		sqlStmt := `
+ALTER TABLE outbox ADD COLUMN post_author text not null default '';
+ALTER TABLE outbox ADD COLUMN post_categories text not null default '';
+ALTER TABLE outbox ADD COLUMN post_summary text not null default '';
+ALTER TABLE chats ADD COLUMN chat_template text not null default '';
+ALTER TABLE subscriptions ADD COLUMN subscription_template text not null default '';
+UPDATE migrations SET version = 14 WHERE ROWID = 0;
+`
+
+		_, err := DB.Exec(sqlStmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+func V15() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 15 if needed
+	if version < 15 {
+		fmt.Println("Migrating database to version 15")
+		sqlStmt := `
+ALTER TABLE chats ADD COLUMN chat_summary_length integer not null default 0;
+UPDATE migrations SET version = 15 WHERE ROWID = 0;
+`
+
+		_, err := DB.Exec(sqlStmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+func V18() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 18 if needed
+	if version < 18 {
+		fmt.Println("Migrating database to version 18")
+		sqlStmt := `
+ALTER TABLE feeds ADD COLUMN feed_title_policy text not null default 'feed';
+ALTER TABLE feeds ADD COLUMN feed_og_description integer not null default 0;
+UPDATE migrations SET version = 18 WHERE ROWID = 0;
+`
+
+		_, err := DB.Exec(sqlStmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
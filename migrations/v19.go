@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+func V19() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 19 if needed
+	if version < 19 {
+		fmt.Println("Migrating database to version 19")
+		sqlStmt := `
+CREATE TABLE IF NOT EXISTS link_metadata (
+	link text not null primary key,
+	link_title text not null default '',
+	link_image text not null default '',
+	link_description text not null default '',
+	link_fetched_at timestamp not null,
+	link_status integer not null default 0
+);
+CREATE INDEX IF NOT EXISTS link_metadata_fetched_at ON link_metadata (link_fetched_at);
+UPDATE migrations SET version = 19 WHERE ROWID = 0;
+`
+
+		_, err := DB.Exec(sqlStmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+func V4() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 4 if needed
+	if version < 4 {
+		fmt.Println("Migrating database to version 4")
+		sqlStmt := `
+ALTER TABLE feeds ADD COLUMN feed_source text not null default 'rss';
+UPDATE feeds SET feed_source = 'docker' WHERE feed_url LIKE 'https://hub.docker.com/%';
+UPDATE migrations SET version = 4 WHERE ROWID = 0;
+`
+
+		_, err := DB.Exec(sqlStmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
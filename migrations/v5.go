@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+func V5() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 5 if needed
+	if version < 5 {
+		fmt.Println("Migrating database to version 5")
+		sqlStmt := `
+CREATE TABLE IF NOT EXISTS seen_items (
+	feed_id integer not null,
+	item_key text not null,
+	seen_at timestamp not null,
+	PRIMARY KEY (feed_id, item_key)
+);
+CREATE INDEX IF NOT EXISTS seen_items_feed_id_seen_at ON seen_items (feed_id, seen_at);
+UPDATE migrations SET version = 5 WHERE ROWID = 0;
+`
+
+		_, err := DB.Exec(sqlStmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
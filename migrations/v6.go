@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+func V6() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 6 if needed
+	if version < 6 {
+		fmt.Println("Migrating database to version 6")
+		sqlStmt := `
+CREATE TABLE IF NOT EXISTS outbox (
+	outbox_id integer primary key autoincrement,
+	chat_id integer not null,
+	feed_id integer not null,
+	post_title text not null,
+	post_link text not null,
+	post_date timestamp not null,
+	post_photo text not null,
+	status text not null,
+	attempts integer not null default 0,
+	last_error text not null default '',
+	created_at timestamp not null,
+	next_attempt_at timestamp not null
+);
+CREATE INDEX IF NOT EXISTS outbox_status_next_attempt_at ON outbox (status, next_attempt_at);
+UPDATE migrations SET version = 6 WHERE ROWID = 0;
+`
+
+		_, err := DB.Exec(sqlStmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+func V7() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 7 if needed
+	if version < 7 {
+		fmt.Println("Migrating database to version 7")
+		sqlStmt := `
+CREATE TABLE IF NOT EXISTS filters (
+	filter_id integer primary key autoincrement,
+	subscription_id integer not null,
+	filter_action text not null,
+	filter_field text not null,
+	filter_pattern text not null,
+	filter_regex boolean not null default 0
+);
+CREATE INDEX IF NOT EXISTS filters_subscription_id ON filters (subscription_id);
+UPDATE migrations SET version = 7 WHERE ROWID = 0;
+`
+
+		_, err := DB.Exec(sqlStmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+func V8() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 8 if needed
+	if version < 8 {
+		fmt.Println("Migrating database to version 8")
+		// Existing feeds are scheduled at a random time in the next 10 minutes, to spread the load
+		sqlStmt := `
+ALTER TABLE feeds ADD COLUMN feed_next_fetch_at timestamp not null default '1970-01-01 00:00:00';
+ALTER TABLE feeds ADD COLUMN feed_interval integer not null default 0;
+ALTER TABLE subscriptions ADD COLUMN subscription_interval integer not null default 0;
+UPDATE feeds SET feed_next_fetch_at = datetime('now', '+' || (abs(random()) % 600) || ' seconds');
+CREATE INDEX IF NOT EXISTS feeds_feed_next_fetch_at ON feeds (feed_next_fetch_at);
+CREATE INDEX IF NOT EXISTS subscriptions_feed_id ON subscriptions (feed_id);
+UPDATE migrations SET version = 8 WHERE ROWID = 0;
+`
+
+		_, err := DB.Exec(sqlStmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
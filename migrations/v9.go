@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ItalyPaleAle/rss-bot/db"
+)
+
+func V9() error {
+	DB := db.GetDB()
+
+	// Get the version
+	res := &struct {
+		Version int
+	}{}
+	err := DB.Get(res, "SELECT * FROM migrations WHERE ROWID = 0")
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	version := res.Version
+
+	// Update to version 9 if needed
+	if version < 9 {
+		fmt.Println("Migrating database to version 9")
+		sqlStmt := `
+ALTER TABLE feeds ADD COLUMN feed_failures integer not null default 0;
+ALTER TABLE feeds ADD COLUMN feed_last_error text not null default '';
+ALTER TABLE feeds ADD COLUMN feed_last_success timestamp not null default '1970-01-01 00:00:00';
+ALTER TABLE feeds ADD COLUMN feed_failure_notified boolean not null default 0;
+ALTER TABLE feeds ADD COLUMN feed_paused boolean not null default 0;
+UPDATE feeds SET feed_last_success = datetime('now');
+ALTER TABLE outbox ADD COLUMN message text not null default '';
+UPDATE migrations SET version = 9 WHERE ROWID = 0;
+`
+
+		_, err := DB.Exec(sqlStmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
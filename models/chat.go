@@ -0,0 +1,26 @@
+package models
+
+// What to do with messages sent during a chat's quiet hours
+const (
+	// Messages are held and delivered together when the quiet hours end
+	QuietModeHold = "hold"
+	// Messages are delivered right away, but without a notification
+	QuietModeSilent = "silent"
+)
+
+// Model for the chats table, which contains the settings of each chat
+// Chats that don't have a row use the default settings
+type Chat struct {
+	ID       int64  `db:"chat_id"`
+	Backfill int    `db:"chat_backfill"`
+	Timezone string `db:"chat_timezone"`
+	// Quiet hours, in the format "23:00-07:00"; empty if disabled
+	QuietHours string `db:"chat_quiet_hours"`
+	QuietMode  string `db:"chat_quiet_mode"`
+	// Template for messages; empty to use the default one
+	Template string `db:"chat_template"`
+	// Maximum length of the post's summary included in messages; 0 if summaries are disabled
+	SummaryLength int `db:"chat_summary_length"`
+	// If false, images of posts are not sent
+	Images bool `db:"chat_images"`
+}
@@ -2,15 +2,56 @@ package models
 
 import "time"
 
+// When the web page of posts is requested to get their metadata
+const (
+	// The page is always requested
+	FeedMetadataAlways = "always"
+	// The page is requested only if the feed doesn't include an image for the post
+	FeedMetadataFallback = "fallback"
+	// The page is never requested
+	FeedMetadataNever = "never"
+)
+
+// Which title is used for posts when the web page is requested
+const (
+	// The title of the item in the feed
+	FeedTitleFeed = "feed"
+	// The OpenGraph title of the web page, if any
+	FeedTitleOpenGraph = "opengraph"
+	// The longer of the two
+	FeedTitleLonger = "longer"
+)
+
 // Model for the feeds table
 type Feed struct {
 	ID            int64     `db:"feed_id"`
 	Url           string    `db:"feed_url"`
+	CanonicalUrl  string    `db:"feed_canonical_url"`
 	Title         string    `db:"feed_title"`
+	Source        string    `db:"feed_source"`
 	LastModified  time.Time `db:"feed_last_modified"`
 	ETag          string    `db:"feed_etag"`
 	LastPostTitle string    `db:"feed_last_post_title"`
 	LastPostLink  string    `db:"feed_last_post_link"`
 	LastPostDate  time.Time `db:"feed_last_post_date"`
 	LastPostPhoto string    `db:"feed_last_post_photo"`
+	NextFetchAt   time.Time `db:"feed_next_fetch_at"`
+	Interval      int64     `db:"feed_interval"`
+	Failures      int       `db:"feed_failures"`
+	LastError     string    `db:"feed_last_error"`
+	LastSuccess   time.Time `db:"feed_last_success"`
+	Notified      bool      `db:"feed_failure_notified"`
+	Paused        bool      `db:"feed_paused"`
+	// When to request the web page of posts for their metadata; one of the FeedMetadata constants
+	Metadata string `db:"feed_metadata"`
+	// Which title is used for posts; one of the FeedTitle constants
+	TitlePolicy string `db:"feed_title_policy"`
+	// If true, the OpenGraph description of the web page is used as summary for posts that don't have one in the feed
+	OGDescription bool `db:"feed_og_description"`
+
+	// If the feed has moved permanently, this is the new URL; it's not stored in the database
+	MovedTo string `db:"-"`
+	// Number of items that were skipped when the feed was last parsed, because of an invalid date or an empty title; not stored in the database
+	SkippedInvalidDate int `db:"-"`
+	SkippedEmptyTitle  int `db:"-"`
 }
@@ -0,0 +1,26 @@
+package models
+
+// Actions for filters
+const (
+	FilterActionInclude = "include"
+	FilterActionExclude = "exclude"
+)
+
+// Fields filters can match on
+const (
+	FilterFieldAny      = "any"
+	FilterFieldTitle    = "title"
+	FilterFieldLink     = "link"
+	FilterFieldCategory = "category"
+	FilterFieldAuthor   = "author"
+)
+
+// Model for the filters table
+type Filter struct {
+	ID             int64  `db:"filter_id"`
+	SubscriptionID int64  `db:"subscription_id"`
+	Action         string `db:"filter_action"`
+	Field          string `db:"filter_field"`
+	Pattern        string `db:"filter_pattern"`
+	Regex          bool   `db:"filter_regex"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Model for the link_metadata table, which caches the metadata of web pages
+type LinkMetadata struct {
+	Link        string    `db:"link"`
+	Title       string    `db:"link_title"`
+	Image       string    `db:"link_image"`
+	Description string    `db:"link_description"`
+	FetchedAt   time.Time `db:"link_fetched_at"`
+	// HTTP status code of the response, or 0 if the request failed
+	Status int `db:"link_status"`
+}
+
+// OK returns true if the web page was fetched successfully
+func (l *LinkMetadata) OK() bool {
+	return l.Status >= 200 && l.Status < 300
+}
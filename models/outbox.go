@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Status of messages in the outbox
+const (
+	OutboxStatusPending = "pending"
+	// The message is being sent; if the result can't be recorded, it stays in this status and it's not sent again
+	OutboxStatusSending   = "sending"
+	OutboxStatusDelivered = "delivered"
+	OutboxStatusFailed    = "failed"
+)
+
+// Model for the outbox table
+type OutboxMessage struct {
+	ID             int64     `db:"outbox_id"`
+	ChatID         int64     `db:"chat_id"`
+	FeedID         int64     `db:"feed_id"`
+	PostTitle      string    `db:"post_title"`
+	PostLink       string    `db:"post_link"`
+	PostDate       time.Time `db:"post_date"`
+	PostPhoto      string    `db:"post_photo"`
+	PostAuthor     string    `db:"post_author"`
+	PostCategories string    `db:"post_categories"` // Separated by newlines
+	PostSummary    string    `db:"post_summary"`
+	Message        string    `db:"message"`
+	PhotoOnly      bool      `db:"photo_only"` // If true, only the post's photo is sent
+	Status         string    `db:"status"`
+	Attempts       int       `db:"attempts"`
+	LastError      string    `db:"last_error"`
+	CreatedAt      time.Time `db:"created_at"`
+	NextAttemptAt  time.Time `db:"next_attempt_at"`
+}
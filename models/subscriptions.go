@@ -1,8 +1,35 @@
 package models
 
+import "time"
+
+// Delivery modes for subscriptions
+// Besides instant delivery, posts can be collected and sent as a digest every hour, day or week
+// The stored value includes the time for daily and weekly digests, such as "daily 08:00" or "weekly mon 08:00"
+const (
+	DeliveryInstant = "instant"
+	DeliveryHourly  = "hourly"
+	DeliveryDaily   = "daily"
+	DeliveryWeekly  = "weekly"
+)
+
 // Model for the subscriptions table
 type Subscription struct {
-	ID     int64 `db:"subscription_id"`
-	FeedID int64 `db:"feed_id"`
-	ChatID int64 `db:"chat_id"`
+	ID           int64     `db:"subscription_id"`
+	FeedID       int64     `db:"feed_id"`
+	ChatID       int64     `db:"chat_id"`
+	Interval     int64     `db:"subscription_interval"`
+	Delivery     string    `db:"subscription_delivery"`
+	NextDigestAt time.Time `db:"subscription_next_digest_at"`
+	// Template for messages, overriding the chat's; empty to use the chat's template
+	Template string `db:"subscription_template"`
+}
+
+// Model for the digest_items table, which contains posts waiting to be sent in a digest
+type DigestItem struct {
+	ID             int64     `db:"digest_item_id"`
+	SubscriptionID int64     `db:"subscription_id"`
+	PostTitle      string    `db:"post_title"`
+	PostLink       string    `db:"post_link"`
+	PostDate       time.Time `db:"post_date"`
+	CreatedAt      time.Time `db:"created_at"`
 }
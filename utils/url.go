@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Query string parameters that are used for tracking, and are removed from URLs
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"igshid":  true,
+	"_ga":     true,
+}
+
+// CanonicalURL normalizes a URL, so the same feed can be found when it is added with a different URL
+// It lowercases the scheme and host, and removes default ports, trailing slashes, tracking parameters and the fragment
+// URLs that aren't http or https are returned unchanged
+func CanonicalURL(rawUrl string) string {
+	rawUrl = strings.TrimSpace(rawUrl)
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return rawUrl
+	}
+
+	// Host and port
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		// IPv6 address
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	// Path
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+
+	// Query string
+	if u.RawQuery != "" {
+		query := u.Query()
+		for k := range query {
+			if strings.HasPrefix(strings.ToLower(k), "utm_") || trackingParams[strings.ToLower(k)] {
+				query.Del(k)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	u.ForceQuery = false
+
+	// Fragment
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	return u.String()
+}
+
+// URLSchemeVariants returns a canonical URL with both the http and https schemes, with the one passed first
+// This is used to match the same feed when it's requested over a different scheme
+func URLSchemeVariants(canonicalUrl string) []string {
+	switch {
+	case strings.HasPrefix(canonicalUrl, "https://"):
+		return []string{canonicalUrl, "http://" + strings.TrimPrefix(canonicalUrl, "https://")}
+	case strings.HasPrefix(canonicalUrl, "http://"):
+		return []string{canonicalUrl, "https://" + strings.TrimPrefix(canonicalUrl, "http://")}
+	default:
+		return []string{canonicalUrl}
+	}
+}
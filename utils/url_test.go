@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{`https://example.com/feed`, `https://example.com/feed`},
+		// Scheme and host case
+		{`HTTPS://Example.COM/Feed`, `https://example.com/Feed`},
+		// Default ports
+		{`http://example.com:80/feed`, `http://example.com/feed`},
+		{`https://example.com:443/feed`, `https://example.com/feed`},
+		{`https://example.com:8443/feed`, `https://example.com:8443/feed`},
+		{`http://[::1]:80/feed`, `http://[::1]/feed`},
+		// Trailing slashes
+		{`https://example.com/feed/`, `https://example.com/feed`},
+		{`https://example.com/`, `https://example.com`},
+		// Tracking parameters and fragment
+		{`https://example.com/feed?utm_source=a&utm_medium=b`, `https://example.com/feed`},
+		{`https://example.com/feed?format=rss&fbclid=x#top`, `https://example.com/feed?format=rss`},
+		{`https://example.com/feed?b=2&a=1`, `https://example.com/feed?a=1&b=2`},
+		{`https://example.com/feed?`, `https://example.com/feed`},
+		// Spaces around
+		{` https://example.com/feed `, `https://example.com/feed`},
+		// Not http
+		{`example.com/feed`, `example.com/feed`},
+		{`ftp://example.com/feed/`, `ftp://example.com/feed/`},
+	}
+
+	for _, el := range cases {
+		res := CanonicalURL(el.in)
+		if res != el.out {
+			t.Fatalf("Expected result for '%s' to be '%s', but got '%s'", el.in, el.out, res)
+		}
+		// Must be idempotent
+		if again := CanonicalURL(res); again != res {
+			t.Fatalf("Expected result for '%s' to be the same, but got '%s'", res, again)
+		}
+	}
+}